@@ -0,0 +1,91 @@
+package grsync
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const defaultRsyncPath = "rsync"
+
+// CommandOptions configures rsync process
+type CommandOptions struct {
+	// Path to rsync executable, rsync from PATH is used by default
+	Path string
+	// Env contains extra environment variables in "KEY=value" form, e.g.
+	// RSYNC_PASSWORD or SSH_AUTH_SOCK
+	Env []string
+	// Dir is working directory of rsync, relative paths are resolved against it
+	Dir string
+	// Nice runs rsync with nice adjusted niceness (unix only)
+	Nice int
+	// IONiceClass runs rsync with ionice scheduling class: 1 realtime,
+	// 2 best-effort, 3 idle (linux only)
+	IONiceClass int
+	// IONiceLevel priority within realtime and best-effort classes, 0-7
+	IONiceLevel int
+	// SysProcAttr replaces default process attributes. By default rsync runs
+	// in a separate process group which is signalled on cancellation.
+	SysProcAttr *syscall.SysProcAttr
+	// KillTimeout is how long cancelled rsync has to exit after SIGTERM
+	KillTimeout time.Duration
+	// Executor starts rsync process, ExecExecutor is used by default
+	Executor Executor
+	// DestinationMode is how destination directory is created, missing local
+	// destination directory is created by default
+	DestinationMode DestinationMode
+}
+
+// command returns rsync command with arguments
+func (o CommandOptions) command(arguments []string) Command {
+	const idleClass = 3
+
+	name := o.Path
+	if name == "" {
+		name = defaultRsyncPath
+	}
+
+	if o.IONiceClass > 0 {
+		ioniceArgs := []string{"-c", strconv.Itoa(o.IONiceClass)}
+		if o.IONiceClass != idleClass {
+			ioniceArgs = append(ioniceArgs, "-n", strconv.Itoa(o.IONiceLevel))
+		}
+		arguments = append(append(ioniceArgs, name), arguments...)
+		name = "ionice"
+	}
+
+	if o.Nice != 0 {
+		arguments = append([]string{"-n", strconv.Itoa(o.Nice), name}, arguments...)
+		name = "nice"
+	}
+
+	command := Command{
+		Path:        name,
+		Args:        arguments,
+		Dir:         o.Dir,
+		SysProcAttr: o.SysProcAttr,
+	}
+	if len(o.Env) > 0 {
+		command.Env = append(os.Environ(), o.Env...)
+	}
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = processAttr()
+	}
+
+	return command
+}
+
+func (o CommandOptions) executor() Executor {
+	if o.Executor != nil {
+		return o.Executor
+	}
+	return ExecExecutor{}
+}
+
+func (o CommandOptions) killTimeout() time.Duration {
+	if o.KillTimeout > 0 {
+		return o.KillTimeout
+	}
+	return defaultKillTimeout
+}
@@ -0,0 +1,69 @@
+package grsync
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandOptions(t *testing.T) {
+	t.Run("default rsync", func(t *testing.T) {
+		rsync := NewRsync("a", "b", RsyncOptions{Verbose: true})
+		assert.Equal(t, "rsync", rsync.cmd.Path)
+		assert.Equal(t, []string{"--verbose", "a", "b"}, rsync.cmd.Args)
+		assert.Nil(t, rsync.cmd.Env)
+		assert.Equal(t, defaultKillTimeout, rsync.killTimeout)
+	})
+
+	t.Run("custom path, env and dir", func(t *testing.T) {
+		rsync := NewRsyncCommand("a", "b", RsyncOptions{}, CommandOptions{
+			Path:        "/usr/local/bin/rsync",
+			Env:         []string{"RSYNC_PASSWORD=secret"},
+			Dir:         "/tmp",
+			KillTimeout: time.Second,
+		})
+		assert.Equal(t, "/usr/local/bin/rsync", rsync.cmd.Path)
+		assert.Equal(t, []string{"a", "b"}, rsync.cmd.Args)
+		assert.Contains(t, rsync.cmd.Env, "RSYNC_PASSWORD=secret")
+		assert.Equal(t, "/tmp", rsync.cmd.Dir)
+		assert.Equal(t, time.Second, rsync.killTimeout)
+	})
+
+	t.Run("nice and ionice", func(t *testing.T) {
+		rsync := NewRsyncCommand("a", "b", RsyncOptions{}, CommandOptions{
+			Nice:        10,
+			IONiceClass: 2,
+			IONiceLevel: 7,
+		})
+		assert.Equal(t, "nice", rsync.cmd.Path)
+		assert.Equal(t, []string{"-n", "10", "ionice", "-c", "2", "-n", "7", "rsync", "a", "b"}, rsync.cmd.Args)
+	})
+
+	t.Run("idle ionice class has no level", func(t *testing.T) {
+		rsync := NewRsyncCommand("a", "b", RsyncOptions{}, CommandOptions{IONiceClass: 3})
+		assert.Equal(t, "ionice", rsync.cmd.Path)
+		assert.Equal(t, []string{"-c", "3", "rsync", "a", "b"}, rsync.cmd.Args)
+	})
+
+	t.Run("process attributes", func(t *testing.T) {
+		attr := &syscall.SysProcAttr{}
+		rsync := NewRsyncCommand("a", "b", RsyncOptions{}, CommandOptions{SysProcAttr: attr})
+		assert.Equal(t, attr, rsync.cmd.SysProcAttr)
+	})
+
+	t.Run("executor", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncCommand("a", "b", RsyncOptions{}, CommandOptions{Executor: executor})
+		assert.Equal(t, executor, rsync.executor)
+
+		rsync = NewRsync("a", "b", RsyncOptions{})
+		assert.Equal(t, ExecExecutor{}, rsync.executor)
+	})
+
+	t.Run("task", func(t *testing.T) {
+		task := NewTaskCommand("a", "b", RsyncOptions{}, CommandOptions{Path: "/opt/rsync"})
+		assert.Equal(t, "/opt/rsync", task.rsync.cmd.Path)
+	})
+}
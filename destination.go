@@ -0,0 +1,186 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DestinationMode is how destination directory is created before transfer
+type DestinationMode int
+
+const (
+	// DestinationLocal creates missing local destination directory, remote
+	// destinations are left to rsync
+	DestinationLocal DestinationMode = iota
+	// DestinationNone doesn't create destination directory
+	DestinationNone
+	// DestinationMkpath runs rsync with --mkpath, which creates missing
+	// destination path itself, local or remote (rsync 3.2.3 and newer)
+	DestinationMkpath
+	// DestinationRemoteShell creates missing local destination directory,
+	// or remote one with mkdir -p over the remote shell of ssh destination
+	DestinationRemoteShell
+)
+
+// destinationDirMode is permission of created destination directories
+const destinationDirMode = 0755
+
+// prepareDestination creates destination directory according to the mode
+func (r Rsync) prepareDestination(ctx context.Context) error {
+	if r.destinationMode == DestinationNone || r.destinationMode == DestinationMkpath {
+		return nil
+	}
+
+	destination, err := ParseEndpoint(r.Destination)
+	if err != nil {
+		return err
+	}
+
+	switch destination.Kind {
+	case EndpointLocal:
+		return r.createLocalDir(destination.Path)
+	case EndpointSSH:
+		if r.destinationMode == DestinationRemoteShell {
+			return r.createRemoteDir(ctx, destination)
+		}
+	}
+	return nil
+}
+
+// createLocalDir creates missing directory, relative path is resolved against
+// working directory of rsync
+func (r Rsync) createLocalDir(path string) error {
+	if !filepath.IsAbs(path) && r.cmd.Dir != "" {
+		path = filepath.Join(r.cmd.Dir, path)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+	return os.MkdirAll(path, destinationDirMode)
+}
+
+// createRemoteDir runs mkdir -p on the remote host with the remote shell
+// which rsync uses
+func (r Rsync) createRemoteDir(ctx context.Context, destination Endpoint) error {
+	if destination.Path == "" {
+		// Home directory
+		return nil
+	}
+
+	rsh, err := splitShellArgs(r.rsh)
+	if err != nil {
+		return fmt.Errorf("grsync: creating destination %s: invalid remote shell: %w", r.Destination, err)
+	}
+	if len(rsh) == 0 {
+		rsh = []string{"ssh"}
+	}
+	arguments := append(rsh[1:], destination.userHost(), "mkdir", "-p", "--", remotePathQuote(destination.Path))
+
+	p, err := r.startProcess(ctx, Command{
+		Path:        rsh[0],
+		Args:        arguments,
+		Env:         r.cmd.Env,
+		Dir:         r.cmd.Dir,
+		SysProcAttr: r.cmd.SysProcAttr,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("grsync: creating destination %s: %w", r.Destination, err)
+	}
+
+	if err := p.wait(); err != nil {
+		return fmt.Errorf("grsync: creating destination %s: %w", r.Destination, err)
+	}
+	return nil
+}
+
+// shellQuote quotes value for POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// remotePathQuote quotes path for the remote shell, leading ~ or ~user is
+// left unquoted, so the shell expands it to the home directory like rsync does
+func remotePathQuote(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return shellQuote(path)
+	}
+
+	home, rest := path, ""
+	if slash := strings.Index(path, "/"); slash >= 0 {
+		home, rest = path[:slash], path[slash:]
+	}
+	if strings.ContainsAny(home[1:], remoteShellSpecials+"*?[]~") {
+		return shellQuote(path)
+	}
+	if rest == "" || rest == "/" {
+		return home + rest
+	}
+	return home + "/" + shellQuote(rest[1:])
+}
+
+// splitShellArgs splits command line like POSIX shell does without expansions:
+// arguments are separated by whitespace, single quotes keep text as is, and
+// backslash escapes the next character outside quotes, or one of \ " $ ` in
+// double quotes. It is used for Rsh option, e.g.
+// ssh -o "ProxyCommand=ssh -W %h:%p bastion".
+func splitShellArgs(value string) ([]string, error) {
+	var (
+		arguments []string
+		current   strings.Builder
+		inArg     bool
+		quote     rune
+		escaped   bool
+	)
+
+	for _, char := range value {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\\\"$`", char) {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(char)
+			escaped = false
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if char == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '\'' || char == '"':
+			quote, inArg = char, true
+		case char == ' ' || char == '\t' || char == '\n':
+			if inArg {
+				arguments = append(arguments, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+
+	switch {
+	case escaped:
+		return nil, errors.New("trailing backslash")
+	case quote != 0:
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		arguments = append(arguments, current.String())
+	}
+	return arguments, nil
+}
@@ -0,0 +1,188 @@
+package grsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("creates missing local directory", func(t *testing.T) {
+		destination := filepath.Join(dir, "a", "b")
+		rsync := NewRsyncCommand("src", destination, RsyncOptions{}, CommandOptions{Executor: NewFakeExecutor(FakeRun{})})
+
+		assert.NoError(t, rsync.Run())
+		assert.DirExists(t, destination)
+	})
+
+	t.Run("relative to working directory", func(t *testing.T) {
+		rsync := NewRsyncCommand("src", "relative/", RsyncOptions{}, CommandOptions{
+			Dir:      dir,
+			Executor: NewFakeExecutor(FakeRun{}),
+		})
+
+		assert.NoError(t, rsync.Run())
+		assert.DirExists(t, filepath.Join(dir, "relative"))
+	})
+
+	t.Run("existing file is kept", func(t *testing.T) {
+		destination := filepath.Join(dir, "file.txt")
+		assert.NoError(t, ioutil.WriteFile(destination, []byte("data"), 0644))
+
+		rsync := NewRsyncCommand("src", destination, RsyncOptions{}, CommandOptions{Executor: NewFakeExecutor(FakeRun{})})
+		assert.NoError(t, rsync.Run())
+		assert.FileExists(t, destination)
+	})
+
+	t.Run("remote destination isn't created locally", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src", "user@host:/backup", RsyncOptions{}, CommandOptions{Dir: dir, Executor: executor})
+
+		assert.NoError(t, rsync.Run())
+		_, err := os.Stat(filepath.Join(dir, "user@host:"))
+		assert.True(t, os.IsNotExist(err))
+		assert.Len(t, executor.Commands(), 1)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		destination := filepath.Join(dir, "none")
+		rsync := NewRsyncCommand("src", destination, RsyncOptions{}, CommandOptions{
+			DestinationMode: DestinationNone,
+			Executor:        NewFakeExecutor(FakeRun{}),
+		})
+
+		assert.NoError(t, rsync.Run())
+		_, err := os.Stat(destination)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("mkpath", func(t *testing.T) {
+		destination := filepath.Join(dir, "mkpath")
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src", destination, RsyncOptions{}, CommandOptions{
+			DestinationMode: DestinationMkpath,
+			Executor:        executor,
+		})
+
+		assert.NoError(t, rsync.Run())
+		_, err := os.Stat(destination)
+		assert.True(t, os.IsNotExist(err))
+		assert.Contains(t, executor.Commands()[0].Args, "--mkpath")
+	})
+
+	t.Run("remote shell", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		destination, err := ParseEndpoint("ssh://user@host:2222/back'up dir")
+		assert.NoError(t, err)
+
+		rsync := NewRsyncEndpoint(LocalEndpoint("src"), destination, RsyncOptions{}, CommandOptions{
+			DestinationMode: DestinationRemoteShell,
+			Executor:        executor,
+		})
+		assert.NoError(t, rsync.Run())
+
+		commands := executor.Commands()
+		if assert.Len(t, commands, 2) {
+			assert.Equal(t, "ssh", commands[0].Path)
+			assert.Equal(t, []string{"-p", "2222", "user@host", "mkdir", "-p", "--", `'/back'\''up dir'`}, commands[0].Args)
+			assert.Equal(t, "rsync", commands[1].Path)
+		}
+	})
+
+	t.Run("remote shell with quoted options", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src", "host:~/backup", RsyncOptions{
+			Rsh: `ssh -o "ProxyCommand=ssh -W %h:%p bastion" -i '/keys/id rsa'`,
+		}, CommandOptions{
+			DestinationMode: DestinationRemoteShell,
+			Executor:        executor,
+		})
+		assert.NoError(t, rsync.Run())
+
+		commands := executor.Commands()
+		if assert.Len(t, commands, 2) {
+			assert.Equal(t, "ssh", commands[0].Path)
+			assert.Equal(t, []string{
+				"-o", "ProxyCommand=ssh -W %h:%p bastion", "-i", "/keys/id rsa",
+				"host", "mkdir", "-p", "--", "~/'backup'",
+			}, commands[0].Args)
+		}
+	})
+
+	t.Run("invalid remote shell", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src", "host:/backup", RsyncOptions{Rsh: `ssh -o "ProxyCommand`}, CommandOptions{
+			DestinationMode: DestinationRemoteShell,
+			Executor:        executor,
+		})
+
+		err := rsync.Run()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid remote shell")
+		assert.Empty(t, executor.Commands())
+	})
+
+	t.Run("remote shell failure", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{Stderr: "Permission denied\n", ExitCode: 1})
+		rsync := NewRsyncCommand("src", "host:/backup", RsyncOptions{}, CommandOptions{
+			DestinationMode: DestinationRemoteShell,
+			Executor:        executor,
+		})
+
+		err := rsync.Run()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "creating destination host:/backup")
+		assert.Len(t, executor.Commands(), 1)
+	})
+}
+
+func TestRemotePathQuote(t *testing.T) {
+	testCases := map[string]string{
+		"/backup":         "'/backup'",
+		"back'up":         `'back'\''up'`,
+		"~":               "~",
+		"~/":              "~/",
+		"~/backup dir":    "~/'backup dir'",
+		"~user/backup":    "~user/'backup'",
+		"~$(reboot)/x":    `'~$(reboot)/x'`,
+		"dir/~/backup":    "'dir/~/backup'",
+		"~backup with sp": "'~backup with sp'",
+	}
+
+	for path, expected := range testCases {
+		assert.Equal(t, expected, remotePathQuote(path), path)
+	}
+}
+
+func TestSplitShellArgs(t *testing.T) {
+	testCases := []struct {
+		value     string
+		arguments []string
+	}{
+		{"", nil},
+		{"  ssh  -p 22 ", []string{"ssh", "-p", "22"}},
+		{`ssh -o "ProxyCommand=ssh -W %h:%p bastion"`, []string{"ssh", "-o", "ProxyCommand=ssh -W %h:%p bastion"}},
+		{`ssh -i '/keys/a "b"'`, []string{"ssh", "-i", `/keys/a "b"`}},
+		{`ssh -i /keys/a\ b`, []string{"ssh", "-i", "/keys/a b"}},
+		{`ssh -o "a\"b\c"`, []string{"ssh", "-o", `a"b\c`}},
+		{`ssh ""`, []string{"ssh", ""}},
+	}
+
+	for _, testCase := range testCases {
+		arguments, err := splitShellArgs(testCase.value)
+		assert.NoError(t, err, testCase.value)
+		assert.Equal(t, testCase.arguments, arguments, testCase.value)
+	}
+
+	for _, value := range []string{`ssh "a`, `ssh 'a`, `ssh a\`} {
+		_, err := splitShellArgs(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,299 @@
+package grsync
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EndpointKind is how rsync reaches the files of an endpoint
+type EndpointKind string
+
+const (
+	// EndpointLocal is a path on the local machine
+	EndpointLocal EndpointKind = "local"
+	// EndpointSSH is a path on a remote machine reached by remote shell
+	EndpointSSH EndpointKind = "ssh"
+	// EndpointDaemon is a path in a module of rsync daemon
+	EndpointDaemon EndpointKind = "daemon"
+)
+
+const (
+	daemonURLPrefix = "rsync://"
+	sshURLPrefix    = "ssh://"
+)
+
+// Endpoint is rsync source or destination.
+//
+// Trailing slash of Path has rsync meaning: source "dir/" copies contents of
+// the directory, while "dir" copies the directory itself.
+type Endpoint struct {
+	Kind EndpointKind
+	User string
+	// Host is host name or IP address, IPv6 addresses are without brackets
+	Host string
+	// Port of rsync daemon, or of ssh server. Remote shell doesn't have port
+	// syntax, so ssh port is passed to NewRsyncEndpoint with the Rsh option.
+	Port   int
+	Module string
+	// Path of daemon endpoint is relative to the module and starts with a
+	// slash unless it is empty
+	Path string
+}
+
+// LocalEndpoint returns endpoint of local path
+func LocalEndpoint(path string) Endpoint {
+	return Endpoint{Kind: EndpointLocal, Path: path}
+}
+
+// SSHEndpoint returns endpoint of path on the host reached by remote shell
+func SSHEndpoint(user, host, path string) Endpoint {
+	return Endpoint{Kind: EndpointSSH, User: user, Host: host, Path: path}
+}
+
+// DaemonEndpoint returns endpoint of path in the module of rsync daemon
+func DaemonEndpoint(user, host, module, path string) Endpoint {
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return Endpoint{Kind: EndpointDaemon, User: user, Host: host, Module: module, Path: path}
+}
+
+// ParseEndpoint parses rsync source or destination argument:
+//
+//	/local/path
+//	[user@]host:path
+//	[user@][::1]:path
+//	[user@]host::module/path
+//	rsync://[user@]host[:port]/module/path
+//	ssh://[user@]host[:port]/path
+//
+// Like rsync, path with a slash before the first colon is local.
+func ParseEndpoint(value string) (Endpoint, error) {
+	switch {
+	case value == "":
+		return Endpoint{}, errors.New("grsync: empty endpoint")
+	case strings.HasPrefix(value, daemonURLPrefix):
+		return parseEndpointURL(value, EndpointDaemon)
+	case strings.HasPrefix(value, sshURLPrefix):
+		return parseEndpointURL(value, EndpointSSH)
+	}
+
+	var user, host, path string
+	if bracket := strings.Index(value, "["); bracket >= 0 && isIPv6HostStart(value, bracket) {
+		end := strings.Index(value, "]")
+		if end < 0 || !strings.HasPrefix(value[end+1:], ":") {
+			// Not a bracketed IPv6 host, e.g. local file "[1].txt"
+			return LocalEndpoint(value), nil
+		}
+		user = strings.TrimSuffix(value[:bracket], "@")
+		host, path = value[bracket+1:end], value[end+2:]
+	} else {
+		colon := strings.Index(value, ":")
+		if colon <= 0 || strings.Contains(value[:colon], "/") {
+			return LocalEndpoint(value), nil
+		}
+		user, host = splitUser(value[:colon])
+		path = value[colon+1:]
+	}
+
+	if host == "" {
+		return Endpoint{}, fmt.Errorf("grsync: empty host in endpoint %q", value)
+	}
+
+	if strings.HasPrefix(path, ":") {
+		module, modulePath := splitModule(path[1:])
+		return Endpoint{Kind: EndpointDaemon, User: user, Host: host, Module: module, Path: modulePath}, nil
+	}
+	return Endpoint{Kind: EndpointSSH, User: user, Host: host, Path: path}, nil
+}
+
+// isIPv6HostStart reports whether bracket at index starts host of [user@][host]
+func isIPv6HostStart(value string, index int) bool {
+	return index == 0 || value[index-1] == '@' && !strings.ContainsAny(value[:index], "/:")
+}
+
+// parseEndpointURL parses rsync:// and ssh:// endpoints
+func parseEndpointURL(value string, kind EndpointKind) (Endpoint, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(value, daemonURLPrefix), sshURLPrefix)
+
+	authority, path := rest, ""
+	if slash := strings.Index(rest, "/"); slash >= 0 {
+		authority, path = rest[:slash], rest[slash:]
+	}
+
+	user, hostAndPort := splitUser(authority)
+	host, port, err := splitHostPort(hostAndPort)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("grsync: invalid endpoint %q: %w", value, err)
+	}
+	if host == "" {
+		return Endpoint{}, fmt.Errorf("grsync: empty host in endpoint %q", value)
+	}
+
+	endpoint := Endpoint{Kind: kind, User: user, Host: host, Port: port, Path: path}
+	if kind == EndpointDaemon {
+		endpoint.Module, endpoint.Path = splitModule(strings.TrimPrefix(path, "/"))
+	}
+	return endpoint, nil
+}
+
+// splitUser splits user@host, user may contain @
+func splitUser(value string) (string, string) {
+	at := strings.LastIndex(value, "@")
+	if at < 0 {
+		return "", value
+	}
+	return value[:at], value[at+1:]
+}
+
+// splitHostPort splits host[:port] and [ipv6][:port]
+func splitHostPort(value string) (string, int, error) {
+	host, port := value, ""
+	if strings.HasPrefix(value, "[") {
+		end := strings.Index(value, "]")
+		if end < 0 {
+			return "", 0, errors.New("missing ] in IPv6 host")
+		}
+		host, port = value[1:end], value[end+1:]
+		if port != "" && !strings.HasPrefix(port, ":") {
+			return "", 0, fmt.Errorf("unexpected %q after IPv6 host", port)
+		}
+		port = strings.TrimPrefix(port, ":")
+	} else if colon := strings.LastIndex(value, ":"); colon >= 0 {
+		host, port = value[:colon], value[colon+1:]
+	}
+
+	if port == "" {
+		return host, 0, nil
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number <= 0 || number > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, number, nil
+}
+
+// splitModule splits module/path into module and path with leading slash
+func splitModule(value string) (string, string) {
+	slash := strings.Index(value, "/")
+	if slash < 0 {
+		return value, ""
+	}
+	return value[:slash], value[slash:]
+}
+
+// IsRemote reports whether endpoint isn't on the local machine
+func (e Endpoint) IsRemote() bool {
+	return e.Kind == EndpointSSH || e.Kind == EndpointDaemon
+}
+
+// Contents reports whether path ends with a slash, so rsync copies contents
+// of the directory instead of the directory itself
+func (e Endpoint) Contents() bool {
+	return strings.HasSuffix(e.Path, "/")
+}
+
+// WithContents returns endpoint with trailing slash added or removed
+func (e Endpoint) WithContents(contents bool) Endpoint {
+	switch {
+	case contents && !e.Contents():
+		if e.Kind == EndpointLocal && e.Path == "" {
+			e.Path = "."
+		}
+		e.Path += "/"
+	case !contents && e.Contents():
+		path := strings.TrimRight(e.Path, "/")
+		if path == "" && e.Kind != EndpointDaemon {
+			// Root directory has no form without slash
+			path = "/"
+		}
+		e.Path = path
+	}
+	return e
+}
+
+// String formats endpoint as rsync argument. Local path which looks like a
+// remote one is prefixed with ./, daemon endpoint with port is formatted as
+// rsync:// URL. Remote paths aren't escaped: NewRsyncEndpoints and
+// NewTaskEndpoints set ProtectArgs option for paths with spaces or shell
+// special characters, and rsync 3.2.4 and newer protects them itself.
+func (e Endpoint) String() string {
+	switch e.Kind {
+	case EndpointSSH:
+		return e.userHost() + ":" + e.Path
+	case EndpointDaemon:
+		if e.Port != 0 {
+			return daemonURLPrefix + e.userHostPort() + "/" + e.Module + e.Path
+		}
+		return e.userHost() + "::" + e.Module + e.Path
+	}
+
+	if colon := strings.Index(e.Path, ":"); colon >= 0 && !strings.Contains(e.Path[:colon], "/") {
+		return "./" + e.Path
+	}
+	return e.Path
+}
+
+func (e Endpoint) userHost() string {
+	host := e.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if e.User != "" {
+		return e.User + "@" + host
+	}
+	return host
+}
+
+func (e Endpoint) userHostPort() string {
+	if e.Port != 0 {
+		return e.userHost() + ":" + strconv.Itoa(e.Port)
+	}
+	return e.userHost()
+}
+
+// remoteShellSpecials are characters which the remote shell interprets in
+// paths unless rsync protects them, rsync daemon splits paths on whitespace
+// too. Wildcards and tilde are left out, rsync expands them itself.
+const remoteShellSpecials = " \t\n'\"\\$&|;<>()`!#{}"
+
+// endpointOptions adapts options to the endpoints: ssh port is passed to the
+// remote shell, and remote paths with spaces or shell special characters are
+// protected with --protect-args, since rsync before 3.2.4 passes them to the
+// remote shell or daemon as is
+func endpointOptions(options RsyncOptions, sources []Endpoint, destination Endpoint) RsyncOptions {
+	endpoints := append(append([]Endpoint{}, sources...), destination)
+
+	options.Rsh = endpointsRsh(options.Rsh, endpoints...)
+	for _, endpoint := range endpoints {
+		if endpoint.IsRemote() && strings.ContainsAny(endpoint.Module+endpoint.Path, remoteShellSpecials) {
+			options.ProtectArgs = true
+		}
+	}
+	return options
+}
+
+// endpointsRsh returns remote shell command which connects to the port of
+// the first ssh endpoint with port, rsync uses a single connection
+func endpointsRsh(rsh string, endpoints ...Endpoint) string {
+	for _, endpoint := range endpoints {
+		if endpoint.Kind != EndpointSSH || endpoint.Port == 0 {
+			continue
+		}
+		if rsh == "" {
+			rsh = "ssh"
+		}
+		return fmt.Sprintf("%s -p %d", rsh, endpoint.Port)
+	}
+	return rsh
+}
+
+func endpointStrings(endpoints []Endpoint) []string {
+	values := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		values = append(values, endpoint.String())
+	}
+	return values
+}
@@ -0,0 +1,164 @@
+package grsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	testCases := []struct {
+		value    string
+		endpoint Endpoint
+		// formatted is expected String(), the value itself if empty
+		formatted string
+	}{
+		{"/data/backup/", LocalEndpoint("/data/backup/"), ""},
+		{"relative", LocalEndpoint("relative"), ""},
+		{"./file:with:colons", LocalEndpoint("./file:with:colons"), ""},
+		{"dir/file:name", LocalEndpoint("dir/file:name"), ""},
+		{"[1].txt", LocalEndpoint("[1].txt"), ""},
+		{"host:", SSHEndpoint("", "host", ""), ""},
+		{"host:/data", SSHEndpoint("", "host", "/data"), ""},
+		{"user@host:data dir/", SSHEndpoint("user", "host", "data dir/"), ""},
+		{"user@host:a:b", SSHEndpoint("user", "host", "a:b"), ""},
+		{"[::1]:/data", SSHEndpoint("", "::1", "/data"), ""},
+		{"user@[fe80::1%eth0]:/data", SSHEndpoint("user", "fe80::1%eth0", "/data"), ""},
+		{"host::module", DaemonEndpoint("", "host", "module", ""), ""},
+		{"host::module/", DaemonEndpoint("", "host", "module", "/"), ""},
+		{"user@host::module/path/file", DaemonEndpoint("user", "host", "module", "path/file"), ""},
+		{"[::1]::module/path", DaemonEndpoint("", "::1", "module", "path"), ""},
+		{"rsync://host/module/path", DaemonEndpoint("", "host", "module", "path"), "host::module/path"},
+		{
+			"rsync://user@host:8873/module/path/",
+			Endpoint{Kind: EndpointDaemon, User: "user", Host: "host", Port: 8873, Module: "module", Path: "/path/"},
+			"",
+		},
+		{
+			"rsync://[2001:db8::1]:873/module",
+			Endpoint{Kind: EndpointDaemon, Host: "2001:db8::1", Port: 873, Module: "module"},
+			"",
+		},
+		{
+			"ssh://user@host:2222/data",
+			Endpoint{Kind: EndpointSSH, User: "user", Host: "host", Port: 2222, Path: "/data"},
+			"user@host:/data",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.value, func(t *testing.T) {
+			endpoint, err := ParseEndpoint(testCase.value)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.endpoint, endpoint)
+
+			formatted := testCase.formatted
+			if formatted == "" {
+				formatted = testCase.value
+			}
+			assert.Equal(t, formatted, endpoint.String())
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, value := range []string{"", "@:path", "rsync://", "rsync://host:port/module", "rsync://[::1/module", "ssh://[::1]x/path"} {
+			_, err := ParseEndpoint(value)
+			assert.Error(t, err, value)
+		}
+	})
+}
+
+func TestEndpointString(t *testing.T) {
+	t.Run("local path with colon", func(t *testing.T) {
+		assert.Equal(t, "./file:name", LocalEndpoint("file:name").String())
+		assert.Equal(t, "/abs/file:name", LocalEndpoint("/abs/file:name").String())
+		assert.Equal(t, "./[::1]:path", LocalEndpoint("[::1]:path").String())
+
+		endpoint, err := ParseEndpoint(LocalEndpoint("file:name").String())
+		assert.NoError(t, err)
+		assert.Equal(t, EndpointLocal, endpoint.Kind)
+	})
+
+	t.Run("IPv6 host", func(t *testing.T) {
+		assert.Equal(t, "[::1]:/data", SSHEndpoint("", "::1", "/data").String())
+	})
+}
+
+func TestEndpointContents(t *testing.T) {
+	assert.True(t, LocalEndpoint("dir/").Contents())
+	assert.False(t, LocalEndpoint("dir").Contents())
+
+	assert.Equal(t, "dir/", LocalEndpoint("dir").WithContents(true).Path)
+	assert.Equal(t, "dir", LocalEndpoint("dir//").WithContents(false).Path)
+	assert.Equal(t, "./", LocalEndpoint("").WithContents(true).Path)
+	assert.Equal(t, "/", LocalEndpoint("/").WithContents(false).Path)
+	assert.Equal(t, "host:data/", SSHEndpoint("", "host", "data").WithContents(true).String())
+	assert.Equal(t, "host::module/", DaemonEndpoint("", "host", "module", "").WithContents(true).String())
+	assert.Equal(t, "host::module", DaemonEndpoint("", "host", "module", "/").WithContents(false).String())
+}
+
+func TestNewRsyncEndpoint(t *testing.T) {
+	source := LocalEndpoint("/data/")
+	destination, err := ParseEndpoint("ssh://backup@[2001:db8::1]:2222/backups")
+	assert.NoError(t, err)
+
+	rsync := NewRsyncEndpoint(source, destination, RsyncOptions{}, CommandOptions{})
+	assert.Equal(t, "/data/", rsync.Source)
+	assert.Equal(t, "backup@[2001:db8::1]:/backups", rsync.Destination)
+	assert.Equal(t, []string{"--rsh", "ssh -p 2222", "/data/", "backup@[2001:db8::1]:/backups"}, rsync.cmd.Args)
+
+	task := NewTaskEndpoint(source, destination, RsyncOptions{Rsh: "ssh -i key"}, CommandOptions{})
+	assert.Contains(t, task.GetArguments(), "ssh -i key -p 2222")
+}
+
+func TestEndpointProtectArgs(t *testing.T) {
+	testCases := []struct {
+		destination string
+		protect     bool
+	}{
+		{"host:/path with space", true},
+		{"host:/it's", true},
+		{"host:/$HOME;rm", true},
+		{"host:/plain/path", false},
+		{"host:~/backup/*.tar", false},
+		{"host::module/path", false},
+		{"host::module/path with space", true},
+		{"rsync://host:873/module/path with space", true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.destination, func(t *testing.T) {
+			destination, err := ParseEndpoint(testCase.destination)
+			assert.NoError(t, err)
+
+			rsync := NewRsyncEndpoint(LocalEndpoint("/data/"), destination, RsyncOptions{}, CommandOptions{})
+			assert.Equal(t, testCase.protect, contains(rsync.cmd.Args, "--protect-args"))
+			assert.Equal(t, testCase.destination, rsync.cmd.Args[len(rsync.cmd.Args)-1])
+
+			task := NewTaskEndpoint(LocalEndpoint("/data/"), destination, RsyncOptions{}, CommandOptions{})
+			assert.Equal(t, testCase.protect, contains(task.GetArguments(), "--protect-args"))
+		})
+	}
+
+	t.Run("daemon endpoint", func(t *testing.T) {
+		source := DaemonEndpoint("", "host", "m", "/dir with space")
+		rsync := NewRsyncEndpoints([]Endpoint{source}, LocalEndpoint("backup"), RsyncOptions{}, CommandOptions{})
+		assert.Contains(t, rsync.cmd.Args, "--protect-args")
+		assert.Contains(t, rsync.cmd.Args, "host::m/dir with space")
+	})
+
+	t.Run("remote source", func(t *testing.T) {
+		source := SSHEndpoint("", "host", "/photos 2020/")
+		rsync := NewRsyncEndpoints([]Endpoint{source}, LocalEndpoint("backup"), RsyncOptions{}, CommandOptions{})
+		assert.Contains(t, rsync.cmd.Args, "--protect-args")
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
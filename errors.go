@@ -0,0 +1,202 @@
+package grsync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// stderrTailSize is how many bytes of stderr RsyncError keeps
+const stderrTailSize = 4096
+
+var (
+	// ErrNotRunning is returned by Task.Pause and Task.Resume if the task
+	// isn't running
+	ErrNotRunning = errors.New("grsync: task is not running")
+	// ErrPauseUnsupported is returned by Task.Pause and Task.Resume on
+	// platforms which can't stop processes
+	ErrPauseUnsupported = errors.New("grsync: pause is not supported on this platform")
+	// ErrNoSources is returned by Run if the source list is empty, rsync
+	// would only list the destination then
+	ErrNoSources = errors.New("grsync: no sources")
+)
+
+// ExitCode is rsync exit code
+type ExitCode int
+
+// Exit codes from rsync errcode.h
+const (
+	ExitSuccess            ExitCode = 0
+	ExitSyntax             ExitCode = 1
+	ExitProtocol           ExitCode = 2
+	ExitFileSelect         ExitCode = 3
+	ExitUnsupported        ExitCode = 4
+	ExitStartClient        ExitCode = 5
+	ExitLogFileAppend      ExitCode = 6
+	ExitSocketIO           ExitCode = 10
+	ExitFileIO             ExitCode = 11
+	ExitStreamIO           ExitCode = 12
+	ExitMessageIO          ExitCode = 13
+	ExitIPC                ExitCode = 14
+	ExitSignal             ExitCode = 20
+	ExitWaitChild          ExitCode = 21
+	ExitMalloc             ExitCode = 22
+	ExitPartial            ExitCode = 23
+	ExitVanished           ExitCode = 24
+	ExitDelLimit           ExitCode = 25
+	ExitTimeout            ExitCode = 30
+	ExitConnectionTimeout  ExitCode = 35
+	ExitCommandFailed      ExitCode = 124
+	ExitCommandKilled      ExitCode = 125
+	ExitCommandNotRunnable ExitCode = 126
+	ExitCommandNotFound    ExitCode = 127
+	ExitUnexplained        ExitCode = 255
+	exitCodeKilledBySignal ExitCode = -1
+	exitCodeNotExited      ExitCode = -2
+)
+
+var exitCodeMeanings = map[ExitCode]string{
+	ExitSuccess:            "success",
+	ExitSyntax:             "syntax or usage error",
+	ExitProtocol:           "protocol incompatibility",
+	ExitFileSelect:         "errors selecting input/output files, dirs",
+	ExitUnsupported:        "requested action not supported",
+	ExitStartClient:        "error starting client-server protocol",
+	ExitLogFileAppend:      "daemon unable to append to log-file",
+	ExitSocketIO:           "error in socket I/O",
+	ExitFileIO:             "error in file I/O",
+	ExitStreamIO:           "error in rsync protocol data stream",
+	ExitMessageIO:          "errors with program diagnostics",
+	ExitIPC:                "error in IPC code",
+	ExitSignal:             "received SIGUSR1 or SIGINT",
+	ExitWaitChild:          "some error returned by waitpid()",
+	ExitMalloc:             "error allocating core memory buffers",
+	ExitPartial:            "partial transfer due to error",
+	ExitVanished:           "partial transfer due to vanished source files",
+	ExitDelLimit:           "the --max-delete limit stopped deletions",
+	ExitTimeout:            "timeout in data send/receive",
+	ExitConnectionTimeout:  "timeout waiting for daemon connection",
+	ExitCommandFailed:      "remote shell failed",
+	ExitCommandKilled:      "remote shell killed",
+	ExitCommandNotRunnable: "remote command could not be run",
+	ExitCommandNotFound:    "remote command not found",
+	ExitUnexplained:        "unexplained error, usually failed remote shell connection",
+	exitCodeKilledBySignal: "killed by signal",
+	exitCodeNotExited:      "rsync didn't run",
+}
+
+// String returns meaning of the exit code
+func (c ExitCode) String() string {
+	if meaning, ok := exitCodeMeanings[c]; ok {
+		return meaning
+	}
+	return "unknown error"
+}
+
+// IsPartial reports whether some files were transferred before the error
+func (c ExitCode) IsPartial() bool {
+	return c == ExitPartial || c == ExitVanished
+}
+
+// IsRetryable reports whether the error is caused by network or timeout and
+// rerunning the transfer may succeed
+func (c ExitCode) IsRetryable() bool {
+	switch c {
+	case ExitStartClient, ExitSocketIO, ExitStreamIO, ExitTimeout, ExitConnectionTimeout, ExitUnexplained:
+		return true
+	}
+	return false
+}
+
+// RsyncError is returned when rsync exits with non-zero code
+type RsyncError struct {
+	Code ExitCode
+	// Stderr contains the tail of rsync stderr output
+	Stderr string
+
+	err error
+}
+
+func (e *RsyncError) Error() string {
+	return fmt.Sprintf("rsync: %s (code %d)", e.Code, e.Code)
+}
+
+// Unwrap returns underlying process error, e.g. *exec.ExitError
+func (e *RsyncError) Unwrap() error {
+	return e.err
+}
+
+// IsPartial reports whether some files were transferred before the error
+func (e *RsyncError) IsPartial() bool {
+	return e.Code.IsPartial()
+}
+
+// IsRetryable reports whether rerunning the transfer may succeed
+func (e *RsyncError) IsRetryable() bool {
+	return e.Code.IsRetryable()
+}
+
+// IsPartial reports whether err is rsync partial transfer error
+func IsPartial(err error) bool {
+	var rsyncErr *RsyncError
+	return errors.As(err, &rsyncErr) && rsyncErr.IsPartial()
+}
+
+// IsRetryable reports whether err is rsync error which may disappear on retry
+func IsRetryable(err error) bool {
+	var rsyncErr *RsyncError
+	return errors.As(err, &rsyncErr) && rsyncErr.IsRetryable()
+}
+
+// newRsyncError wraps exit error of rsync process, other errors are returned as is
+func newRsyncError(err error, stderr string) error {
+	var exitErr exitCoder
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	return &RsyncError{
+		Code:   ExitCode(exitErr.ExitCode()),
+		Stderr: tail(stderr, stderrTailSize),
+		err:    err,
+	}
+}
+
+// tail returns last size bytes of s cut at line start
+func tail(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+
+	s = s[len(s)-size:]
+	if index := strings.IndexByte(s, '\n'); index >= 0 && index < len(s)-1 {
+		s = s[index+1:]
+	}
+	return s
+}
+
+// tailBuffer is io.Writer which keeps only last size bytes, it is safe for
+// concurrent use
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	if len(b.data) > 2*b.size {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.size:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return tail(string(b.data), b.size)
+}
@@ -0,0 +1,41 @@
+package grsync
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRsyncError(t *testing.T) {
+	t.Run("message contains meaning", func(t *testing.T) {
+		err := &RsyncError{Code: ExitProtocol}
+		assert.Equal(t, "rsync: protocol incompatibility (code 2)", err.Error())
+		assert.Equal(t, "unknown error", ExitCode(42).String())
+	})
+
+	t.Run("partial errors", func(t *testing.T) {
+		assert.True(t, IsPartial(&RsyncError{Code: ExitPartial}))
+		assert.True(t, IsPartial(&RsyncError{Code: ExitVanished}))
+		assert.False(t, IsPartial(&RsyncError{Code: ExitTimeout}))
+		assert.False(t, IsPartial(fmt.Errorf("not rsync")))
+	})
+
+	t.Run("retryable errors", func(t *testing.T) {
+		assert.True(t, IsRetryable(&RsyncError{Code: ExitTimeout}))
+		assert.True(t, IsRetryable(&RsyncError{Code: ExitStreamIO}))
+		assert.True(t, IsRetryable(fmt.Errorf("sync failed: %w", &RsyncError{Code: ExitConnectionTimeout})))
+		assert.False(t, IsRetryable(&RsyncError{Code: ExitSyntax}))
+		assert.False(t, IsRetryable(nil))
+	})
+}
+
+func TestTail(t *testing.T) {
+	assert.Equal(t, "short", tail("short", 10))
+	assert.Equal(t, "line3\n", tail("line1\nline2\nline3\n", 8))
+
+	buffer := &tailBuffer{size: 6}
+	fmt.Fprint(buffer, strings.Repeat("x\n", 10), "end\n")
+	assert.Equal(t, "x\nend\n", buffer.String())
+}
@@ -0,0 +1,90 @@
+package grsync
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Command describes rsync process to start
+type Command struct {
+	// Path to the executable
+	Path string
+	// Args are arguments without the program name
+	Args []string
+	// Env is the whole process environment, nil means the current one
+	Env []string
+	Dir string
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	SysProcAttr *syscall.SysProcAttr
+}
+
+// errProcessDone is returned by Process.Signal when the process has already
+// exited and was reaped, so it can't be signalled
+var errProcessDone = errors.New("grsync: process already finished")
+
+// osProcessDone is the text of os.ErrProcessDone, which Go before 1.16
+// returns as an unexported error
+const osProcessDone = "os: process already finished"
+
+// Process is started command
+type Process interface {
+	// Wait waits for the process to exit. Errors of processes exited with
+	// non-zero code must have ExitCode() int method, like *exec.ExitError.
+	Wait() error
+	// Signal sends signal to the process and its children. It returns
+	// errProcessDone if the process has already exited.
+	Signal(sig os.Signal) error
+}
+
+// Executor starts processes, it allows to run rsync without os/exec, e.g.
+// on a remote machine or with FakeExecutor in tests
+type Executor interface {
+	Start(command Command) (Process, error)
+}
+
+// exitCoder is implemented by errors of processes exited with non-zero code
+type exitCoder interface {
+	ExitCode() int
+}
+
+// ExecExecutor starts processes with os/exec, it is used by default
+type ExecExecutor struct{}
+
+// Start starts the command
+func (ExecExecutor) Start(command Command) (Process, error) {
+	cmd := exec.Command(command.Path, command.Args...)
+	cmd.Env = command.Env
+	cmd.Dir = command.Dir
+	cmd.Stdin = command.Stdin
+	cmd.Stdout = command.Stdout
+	cmd.Stderr = command.Stderr
+	cmd.SysProcAttr = command.SysProcAttr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return execProcess{cmd: cmd}, nil
+}
+
+type execProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p execProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p execProcess) Signal(sig os.Signal) error {
+	err := signalProcess(p.cmd.Process, sig)
+	if err != nil && err.Error() == osProcessDone {
+		return errProcessDone
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package grsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FakeRun is scripted result of a single process started by FakeExecutor
+type FakeRun struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// Duration delays exit after the output is written, process exits
+	// earlier if it is terminated or killed
+	Duration time.Duration
+}
+
+// FakeExecutor is Executor which replays scripted runs instead of starting
+// processes and records started commands. It allows to test rsync output
+// processing and error handling without rsync. The last run is repeated if
+// there are more starts than runs.
+type FakeExecutor struct {
+	mu       sync.Mutex
+	runs     []FakeRun
+	commands []Command
+	signals  []os.Signal
+}
+
+// FakeExitError is returned by fake process exited with non-zero code
+type FakeExitError struct {
+	Code int
+}
+
+func (e *FakeExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns exit code of the process
+func (e *FakeExitError) ExitCode() int {
+	return e.Code
+}
+
+// NewFakeExecutor returns executor which replays runs in order
+func NewFakeExecutor(runs ...FakeRun) *FakeExecutor {
+	return &FakeExecutor{runs: runs}
+}
+
+// Start records command and starts the next scripted run
+func (e *FakeExecutor) Start(command Command) (Process, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.runs) == 0 {
+		return nil, errors.New("grsync: no fake runs")
+	}
+
+	run := e.runs[0]
+	if len(e.runs) > 1 {
+		e.runs = e.runs[1:]
+	}
+	e.commands = append(e.commands, command)
+
+	process := &fakeProcess{
+		executor: e,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go process.run(command, run)
+
+	return process, nil
+}
+
+// Commands returns started commands
+func (e *FakeExecutor) Commands() []Command {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Command{}, e.commands...)
+}
+
+// Signals returns signals sent to started processes
+func (e *FakeExecutor) Signals() []os.Signal {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]os.Signal{}, e.signals...)
+}
+
+type fakeProcess struct {
+	executor *FakeExecutor
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+	err      error
+}
+
+func (p *fakeProcess) run(command Command, run FakeRun) {
+	const killedExitCode = -1
+	defer close(p.done)
+
+	writeOutput(command.Stdout, run.Stdout)
+	writeOutput(command.Stderr, run.Stderr)
+
+	timer := time.NewTimer(run.Duration)
+	defer timer.Stop()
+
+	select {
+	case <-p.stop:
+		p.err = &FakeExitError{Code: killedExitCode}
+		return
+	case <-timer.C:
+	}
+
+	if run.ExitCode != 0 {
+		p.err = &FakeExitError{Code: run.ExitCode}
+	}
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Signal records the signal, termination and kill signals stop the process
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	select {
+	case <-p.done:
+		return errProcessDone
+	default:
+	}
+
+	p.executor.mu.Lock()
+	p.executor.signals = append(p.executor.signals, sig)
+	p.executor.mu.Unlock()
+
+	if sig == terminateSignal || sig == os.Kill {
+		p.stopOnce.Do(func() { close(p.stop) })
+	}
+	return nil
+}
+
+func writeOutput(w io.Writer, output string) {
+	if w != nil && output != "" {
+		io.WriteString(w, output)
+	}
+}
@@ -0,0 +1,72 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeExecutor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("replays output into task", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{
+			Stdout: "file.txt\n      1,024 100%    0.00kB/s    0:00:00 (xfr#1, to-chk=0/1)\n",
+			Stderr: "warning\n",
+		})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, task.Run())
+		assert.Equal(t, float64(100), task.State().Progress)
+		assert.Equal(t, []string{"file.txt"}, task.CompletedFiles())
+		assert.Equal(t, "warning\n", task.Log().Stderr)
+
+		commands := executor.Commands()
+		if assert.Len(t, commands, 1) {
+			assert.Equal(t, "rsync", commands[0].Path)
+			assert.Equal(t, append(task.GetArguments(), "a", dir), commands[0].Args)
+		}
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{Stderr: "partial\n", ExitCode: int(ExitPartial)})
+		rsync := NewRsyncCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		err := rsync.Run()
+		assert.True(t, IsPartial(err))
+
+		var rsyncErr *RsyncError
+		if assert.True(t, errors.As(err, &rsyncErr)) {
+			assert.Equal(t, ExitPartial, rsyncErr.Code)
+			assert.Equal(t, "partial\n", rsyncErr.Stderr)
+		}
+	})
+
+	t.Run("cancellation terminates process", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{Duration: time.Hour})
+		rsync := NewRsyncCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		assert.Equal(t, context.DeadlineExceeded, rsync.RunContext(ctx))
+		assert.Equal(t, []os.Signal{terminateSignal}, executor.Signals())
+	})
+
+	t.Run("last run is repeated", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{ExitCode: int(ExitSyntax)}, FakeRun{})
+		rsync := NewRsyncCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		assert.Error(t, rsync.Run())
+		assert.NoError(t, rsync.Run())
+		assert.NoError(t, rsync.Run())
+		assert.Len(t, executor.Commands(), 3)
+	})
+}
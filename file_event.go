@@ -0,0 +1,92 @@
+package grsync
+
+import (
+	"strconv"
+	"strings"
+)
+
+// fileEventFormat is out-format used by Task to report transferred files:
+// itemized changes, file size, bytes transferred and file name
+const fileEventFormat = "%i %l %b %n"
+
+// FileOperation describes what rsync did with a file
+type FileOperation string
+
+const (
+	// FileCreated file didn't exist on receiver
+	FileCreated FileOperation = "created"
+	// FileUpdated file content or symlink target was updated
+	FileUpdated FileOperation = "updated"
+	// FileDeleted file was deleted from receiver
+	FileDeleted FileOperation = "deleted"
+	// FileAttrOnly only file attributes were updated
+	FileAttrOnly FileOperation = "attr-only"
+)
+
+// FileEvent contains information about a single file touched by rsync
+type FileEvent struct {
+	Path string `json:"path"`
+	// Changes is itemized changes string, e.g. >f.st......
+	Changes     string        `json:"changes"`
+	Operation   FileOperation `json:"operation"`
+	Size        int64         `json:"size"`
+	Transferred int64         `json:"transferred"`
+}
+
+// fileEventMatcher matches lines printed with fileEventFormat
+var fileEventMatcher = newMatcher(`^([<>ch.*][fdLDS][ .+?a-zA-Z]{9}|\*deleting +) (\d+) (\d+) (.+)$`)
+
+func parseFileEvent(line string) (FileEvent, bool) {
+	const (
+		indexChanges = iota + 1
+		indexSize
+		indexTransferred
+		indexPath
+	)
+
+	matches := fileEventMatcher.ExtractAllStringSubmatch(line, 1)
+	if len(matches) == 0 {
+		return FileEvent{}, false
+	}
+
+	changes := strings.TrimRight(matches[0][indexChanges], " ")
+	size, _ := strconv.ParseInt(matches[0][indexSize], 10, 64)
+	transferred, _ := strconv.ParseInt(matches[0][indexTransferred], 10, 64)
+
+	return FileEvent{
+		Path:        matches[0][indexPath],
+		Changes:     changes,
+		Operation:   getFileOperation(changes),
+		Size:        size,
+		Transferred: transferred,
+	}, true
+}
+
+// getFileOperation classifies itemized changes string YXcstpoguax
+func getFileOperation(changes string) FileOperation {
+	const attributesIndex = 2
+
+	if strings.HasPrefix(changes, "*deleting") {
+		return FileDeleted
+	}
+
+	attributes := strings.TrimSpace(changes[attributesIndex:])
+	if attributes != "" && strings.Trim(attributes, "+") == "" {
+		return FileCreated
+	}
+
+	switch changes[0] {
+	case '<', '>', 'c', 'h':
+		return FileUpdated
+	}
+
+	return FileAttrOnly
+}
+
+// isFileTransfer reports whether event is about sent or received regular file
+func isFileTransfer(event FileEvent) bool {
+	const fileTypeIndex = 1
+	return len(event.Changes) > fileTypeIndex &&
+		(event.Changes[0] == '<' || event.Changes[0] == '>') &&
+		event.Changes[fileTypeIndex] == 'f'
+}
@@ -0,0 +1,80 @@
+package grsync
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFileEvent(t *testing.T) {
+	t.Run("created file", func(t *testing.T) {
+		event, ok := parseFileEvent(">f+++++++++ 1048576 1048576 dir/new file.txt")
+		assert.True(t, ok)
+		assert.Equal(t, FileEvent{
+			Path:        "dir/new file.txt",
+			Changes:     ">f+++++++++",
+			Operation:   FileCreated,
+			Size:        1048576,
+			Transferred: 1048576,
+		}, event)
+	})
+
+	t.Run("created directory", func(t *testing.T) {
+		event, ok := parseFileEvent("cd+++++++++ 4096 0 dir/")
+		assert.True(t, ok)
+		assert.Equal(t, FileCreated, event.Operation)
+		assert.Equal(t, "dir/", event.Path)
+	})
+
+	t.Run("updated file", func(t *testing.T) {
+		event, ok := parseFileEvent(">f.st...... 2048 312 data.bin")
+		assert.True(t, ok)
+		assert.Equal(t, FileUpdated, event.Operation)
+		assert.Equal(t, ">f.st......", event.Changes)
+		assert.Equal(t, int64(2048), event.Size)
+		assert.Equal(t, int64(312), event.Transferred)
+	})
+
+	t.Run("attributes only", func(t *testing.T) {
+		event, ok := parseFileEvent(".f...p..... 2048 0 data.bin")
+		assert.True(t, ok)
+		assert.Equal(t, FileAttrOnly, event.Operation)
+	})
+
+	t.Run("deleted file", func(t *testing.T) {
+		event, ok := parseFileEvent("*deleting   0 0 old.txt")
+		assert.True(t, ok)
+		assert.Equal(t, FileDeleted, event.Operation)
+		assert.Equal(t, "*deleting", event.Changes)
+		assert.Equal(t, "old.txt", event.Path)
+	})
+
+	t.Run("progress line is not a file event", func(t *testing.T) {
+		_, ok := parseFileEvent("          1.05M 100%  659.30kB/s    0:00:01 (xfr#5, ir-chk=3641/3679)")
+		assert.False(t, ok)
+	})
+}
+
+func TestTaskOnFile(t *testing.T) {
+	task := NewTask("a", "b", RsyncOptions{ItemizeChanges: true})
+	assert.Contains(t, task.GetArguments(), "--out-format="+fileEventFormat)
+
+	var events []FileEvent
+	task.OnFile(func(event FileEvent) {
+		events = append(events, event)
+	})
+
+	processStdout(task, strings.NewReader(
+		"sending incremental file list\n"+
+			">f+++++++++ 1024 1024 a.txt\n"+
+			"          1,024 100%    0.00kB/s    0:00:00 (xfr#1, to-chk=1/2)\n"+
+			"*deleting   0 0 b.txt\n",
+	))
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, "a.txt", events[0].Path)
+	assert.Equal(t, FileCreated, events[0].Operation)
+	assert.Equal(t, "b.txt", events[1].Path)
+	assert.Equal(t, FileDeleted, events[1].Operation)
+}
@@ -0,0 +1,144 @@
+package grsync
+
+import (
+	"fmt"
+)
+
+// FilterAction is type of rsync filter rule
+type FilterAction string
+
+const (
+	// FilterInclude includes matching files
+	FilterInclude FilterAction = "include"
+	// FilterExclude excludes matching files
+	FilterExclude FilterAction = "exclude"
+	// FilterProtect protects matching files from deletion
+	FilterProtect FilterAction = "protect"
+	// FilterRisk allows deletion of matching files protected by earlier rules
+	FilterRisk FilterAction = "risk"
+	// FilterHide hides matching files from the transfer
+	FilterHide FilterAction = "hide"
+	// FilterShow shows matching files hidden by earlier rules
+	FilterShow FilterAction = "show"
+	// FilterMerge reads rules from a file
+	FilterMerge FilterAction = "merge"
+	// FilterDirMerge reads rules from a per-directory file
+	FilterDirMerge FilterAction = "dir-merge"
+	// FilterClear clears the current include/exclude list
+	FilterClear FilterAction = "clear"
+)
+
+// filterActionPrefixes are short names of rule types
+var filterActionPrefixes = map[FilterAction]string{
+	FilterInclude:  "+",
+	FilterExclude:  "-",
+	FilterProtect:  "P",
+	FilterRisk:     "R",
+	FilterHide:     "H",
+	FilterShow:     "S",
+	FilterMerge:    ".",
+	FilterDirMerge: ":",
+	FilterClear:    "!",
+}
+
+// FilterRule is a single rsync filter rule
+type FilterRule struct {
+	Action FilterAction
+	// Modifiers change the rule, e.g. "/" matches absolute path, "!" matches
+	// files which don't match the pattern, "s" and "r" apply the rule to
+	// sending or receiving side only, "p" makes the rule perishable. Merge
+	// rules have their own modifiers like "-", "+", "C", "e", "n", "w".
+	Modifiers string
+	// Pattern of the files, or the file name of merge rules
+	Pattern string
+}
+
+// String formats rule in short form accepted by --filter, e.g. "-/ /tmp"
+func (r FilterRule) String() string {
+	prefix, ok := filterActionPrefixes[r.Action]
+	if !ok {
+		prefix = string(r.Action)
+		if r.Modifiers != "" {
+			prefix += ","
+		}
+	}
+
+	rule := prefix + r.Modifiers
+	if r.Pattern != "" {
+		rule += " " + r.Pattern
+	}
+	return rule
+}
+
+// FilterRules is ordered list of filter rules. Rsync checks rules in order
+// and the first matching one is applied, e.g. include "*/", include "*.go",
+// exclude "*" transfers only go files.
+type FilterRules []FilterRule
+
+// Add returns rules with a rule appended
+func (r FilterRules) Add(action FilterAction, modifiers, pattern string) FilterRules {
+	return append(r, FilterRule{Action: action, Modifiers: modifiers, Pattern: pattern})
+}
+
+// Include returns rules with include rule appended
+func (r FilterRules) Include(pattern string) FilterRules {
+	return r.Add(FilterInclude, "", pattern)
+}
+
+// Exclude returns rules with exclude rule appended
+func (r FilterRules) Exclude(pattern string) FilterRules {
+	return r.Add(FilterExclude, "", pattern)
+}
+
+// Protect returns rules with protect rule appended
+func (r FilterRules) Protect(pattern string) FilterRules {
+	return r.Add(FilterProtect, "", pattern)
+}
+
+// Risk returns rules with risk rule appended
+func (r FilterRules) Risk(pattern string) FilterRules {
+	return r.Add(FilterRisk, "", pattern)
+}
+
+// Hide returns rules with hide rule appended
+func (r FilterRules) Hide(pattern string) FilterRules {
+	return r.Add(FilterHide, "", pattern)
+}
+
+// Show returns rules with show rule appended
+func (r FilterRules) Show(pattern string) FilterRules {
+	return r.Add(FilterShow, "", pattern)
+}
+
+// Merge returns rules with rules of the file appended
+func (r FilterRules) Merge(file string) FilterRules {
+	return r.Add(FilterMerge, "", file)
+}
+
+// DirMerge returns rules with per-directory merge file appended
+func (r FilterRules) DirMerge(file string) FilterRules {
+	return r.Add(FilterDirMerge, "", file)
+}
+
+// Clear returns rules with clear rule appended
+func (r FilterRules) Clear() FilterRules {
+	return r.Add(FilterClear, "", "")
+}
+
+// arguments returns --filter arguments in rules order
+func (r FilterRules) arguments(prefix string) []string {
+	arguments := make([]string, 0, len(r))
+	for _, rule := range r {
+		arguments = append(arguments, fmt.Sprintf("%sfilter=%s", prefix, rule))
+	}
+	return arguments
+}
+
+// Lines returns rules in the format of merge files, e.g. for MergeFrom list
+func (r FilterRules) Lines() []string {
+	lines := make([]string, 0, len(r))
+	for _, rule := range r {
+		lines = append(lines, rule.String())
+	}
+	return lines
+}
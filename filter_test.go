@@ -0,0 +1,71 @@
+package grsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterRule(t *testing.T) {
+	testCases := []struct {
+		rule     FilterRule
+		expected string
+	}{
+		{FilterRule{Action: FilterInclude, Pattern: "*/"}, "+ */"},
+		{FilterRule{Action: FilterExclude, Modifiers: "/", Pattern: "/tmp"}, "-/ /tmp"},
+		{FilterRule{Action: FilterExclude, Modifiers: "!", Pattern: "*.go"}, "-! *.go"},
+		{FilterRule{Action: FilterProtect, Pattern: "keep"}, "P keep"},
+		{FilterRule{Action: FilterRisk, Modifiers: "p", Pattern: "keep"}, "Rp keep"},
+		{FilterRule{Action: FilterHide, Modifiers: "s", Pattern: "*.o"}, "Hs *.o"},
+		{FilterRule{Action: FilterShow, Modifiers: "r", Pattern: "*.o"}, "Sr *.o"},
+		{FilterRule{Action: FilterMerge, Pattern: "/etc/rsync/filters"}, ". /etc/rsync/filters"},
+		{FilterRule{Action: FilterDirMerge, Modifiers: "-n", Pattern: ".rsync-filter"}, ":-n .rsync-filter"},
+		{FilterRule{Action: FilterClear}, "!"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expected, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, testCase.rule.String())
+		})
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	t.Run("rules keep declared order", func(t *testing.T) {
+		args := GetArguments(RsyncOptions{
+			Filters: FilterRules{}.Include("*/").Include("*.go").Exclude("*"),
+		})
+		assert.Equal(t, []string{"--filter=+ */", "--filter=+ *.go", "--filter=- *"}, args)
+	})
+
+	t.Run("builder", func(t *testing.T) {
+		rules := FilterRules{}.
+			DirMerge(".rsync-filter").
+			Protect("backup/").
+			Risk("backup/tmp/").
+			Hide("*.o").
+			Show("keep.o").
+			Merge("rules.txt").
+			Add(FilterExclude, "/", "/cache").
+			Clear()
+
+		assert.Equal(t, []string{
+			"--filter=: .rsync-filter",
+			"--filter=P backup/",
+			"--filter=R backup/tmp/",
+			"--filter=H *.o",
+			"--filter=S keep.o",
+			"--filter=. rules.txt",
+			"--filter=-/ /cache",
+			"--filter=!",
+		}, rules.arguments("--"))
+	})
+
+	t.Run("before exclude and include", func(t *testing.T) {
+		args := GetArguments(RsyncOptions{
+			Filters: FilterRules{}.Include("*.go"),
+			Exclude: []string{"*"},
+		})
+		assert.Equal(t, []string{"--filter=+ *.go", "--exclude=*"}, args)
+	})
+}
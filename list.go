@@ -0,0 +1,209 @@
+package grsync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdinListPath makes rsync read a list from stdin
+const stdinListPath = "-"
+
+// FileList is a list of file names, patterns or filter rules which rsync
+// reads from a file, e.g. with --exclude-from. Long lists don't fit into
+// command line, so Lines are written to a temporary file, which is removed
+// after rsync exits, or to rsync stdin.
+type FileList struct {
+	// Path of an existing list file, "-" is stdin. Lines and Reader are
+	// ignored if it is set.
+	Path string
+	// Lines of the list, they are separated by NUL with From0 option, so
+	// they may contain any characters except NUL, or by new lines otherwise
+	Lines []string
+	// Reader is used instead of Lines for lists which are too long to keep
+	// in memory, it must contain separators itself. Reader is read once per
+	// rsync run, so retries require io.ReadSeeker.
+	Reader io.Reader
+	// Stdin passes Lines or Reader through rsync stdin instead of a
+	// temporary file, only one list can be read from stdin
+	Stdin bool
+
+	// path is where grsync writes Lines, it is assigned by constructors
+	path string
+	// used is true after Reader is read, offset is its start position
+	used   bool
+	offset int64
+}
+
+// isGenerated reports whether grsync writes the list
+func (l FileList) isGenerated() bool {
+	return l.Stdin || len(l.Lines) > 0 || l.Reader != nil
+}
+
+// reader returns reader of the list content, Reader is rewound if it was
+// already read by the previous run
+func (l *FileList) reader(from0 bool) (io.Reader, error) {
+	if l.Reader == nil {
+		content, err := encodeList(l.Lines, from0)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(content), nil
+	}
+
+	seeker, isSeeker := l.Reader.(io.Seeker)
+	switch {
+	case !l.used && isSeeker:
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		l.offset = offset
+	case l.used && !isSeeker:
+		return nil, errors.New("grsync: list reader is already read, use io.ReadSeeker to run rsync again")
+	case l.used:
+		if _, err := seeker.Seek(l.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	l.used = true
+	return l.Reader, nil
+}
+
+// argument returns list file name passed to rsync
+func (l FileList) argument() string {
+	if l.path != "" {
+		return l.path
+	}
+	return l.Path
+}
+
+// listNames are suffixes of temporary list files
+var listNames = []string{"files-from", "exclude-from", "include-from", "merge"}
+
+// fileLists returns pointers to all lists of options in listNames order
+func (o *RsyncOptions) fileLists() []*FileList {
+	return []*FileList{&o.FilesFrom, &o.ExcludeFrom, &o.IncludeFrom, &o.MergeFrom}
+}
+
+// assignListPaths chooses paths of generated lists, so arguments are known
+// before the lists are written
+func (o *RsyncOptions) assignListPaths() {
+	for i, list := range o.fileLists() {
+		switch {
+		case list.Path != "" || list.path != "":
+		case list.Stdin:
+			list.path = stdinListPath
+		case list.isGenerated():
+			list.path = tempListPath(listNames[i])
+		}
+	}
+}
+
+// generatedLists returns lists which grsync writes before rsync starts
+func (o RsyncOptions) generatedLists() []*FileList {
+	var lists []*FileList
+	for _, list := range o.fileLists() {
+		if list.path != "" {
+			generated := *list
+			lists = append(lists, &generated)
+		}
+	}
+	return lists
+}
+
+// tempListPath returns unique path of a temporary list file
+func tempListPath(name string) string {
+	const randomBytes = 8
+
+	random := make([]byte, randomBytes)
+	if _, err := rand.Read(random); err != nil {
+		panic(fmt.Sprintf("grsync: generating list file name: %s", err))
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("grsync-%s-%s", hex.EncodeToString(random), name))
+}
+
+// encodeList joins list lines with the separator expected by rsync
+func encodeList(lines []string, from0 bool) (string, error) {
+	separator, forbidden := "\n", "\r\n"
+	if from0 {
+		separator, forbidden = "\x00", "\x00"
+	}
+
+	var builder strings.Builder
+	for _, line := range lines {
+		if strings.ContainsAny(line, forbidden) {
+			if from0 {
+				return "", fmt.Errorf("grsync: list line %q contains NUL", line)
+			}
+			return "", fmt.Errorf("grsync: list line %q contains new line, use From0 option", line)
+		}
+		builder.WriteString(line)
+		builder.WriteString(separator)
+	}
+	return builder.String(), nil
+}
+
+// writeLists writes generated lists to temporary files and command stdin.
+// Returned cleanup removes the files, it must be called after rsync exits.
+func writeLists(lists []*FileList, from0 bool, command *Command) (func(), error) {
+	var paths []string
+	cleanup := func() {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+	}
+
+	stdinUsed := command.Stdin != nil
+	for _, list := range lists {
+		content, err := list.reader(from0)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		if list.path == stdinListPath {
+			if stdinUsed {
+				cleanup()
+				return nil, errors.New("grsync: only one list can be read from stdin")
+			}
+			stdinUsed = true
+			command.Stdin = content
+			continue
+		}
+
+		if err := writeListFile(list.path, content); err != nil {
+			cleanup()
+			return nil, err
+		}
+		paths = append(paths, list.path)
+	}
+
+	return cleanup, nil
+}
+
+// writeListFile creates list file, it fails if the file exists, so files of
+// other processes aren't overwritten
+func writeListFile(path string, content io.Reader) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package grsync
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listExecutor records lists which rsync would read when it starts
+type listExecutor struct {
+	*FakeExecutor
+	files []map[string]string
+	stdin []string
+}
+
+func newListExecutor(runs ...FakeRun) *listExecutor {
+	return &listExecutor{FakeExecutor: NewFakeExecutor(runs...)}
+}
+
+func (e *listExecutor) Start(command Command) (Process, error) {
+	files := map[string]string{}
+	for _, arg := range command.Args {
+		for _, flag := range []string{"--files-from=", "--exclude-from=", "--include-from=", "--filter=merge "} {
+			if path := strings.TrimPrefix(arg, flag); path != arg && path != stdinListPath {
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				files[flag] = string(content)
+			}
+		}
+	}
+	e.files = append(e.files, files)
+
+	stdin := ""
+	if command.Stdin != nil {
+		content, err := ioutil.ReadAll(command.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		stdin = string(content)
+	}
+	e.stdin = append(e.stdin, stdin)
+
+	return e.FakeExecutor.Start(command)
+}
+
+func listArgument(args []string, flag string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, flag) {
+			return strings.TrimPrefix(arg, flag)
+		}
+	}
+	return ""
+}
+
+func TestFileLists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("lines are written to temporary files", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src/", dir, RsyncOptions{
+			ExcludeFrom: FileList{Lines: []string{"*.tmp", "cache/"}},
+			IncludeFrom: FileList{Lines: []string{"*.go"}},
+			MergeFrom:   FileList{Lines: FilterRules{}.Include("*/").Exclude("*").Lines()},
+		}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, rsync.Run())
+		assert.Equal(t, map[string]string{
+			"--exclude-from=": "*.tmp\ncache/\n",
+			"--include-from=": "*.go\n",
+			"--filter=merge ": "+ */\n- *\n",
+		}, executor.files[0])
+
+		for _, flag := range []string{"--exclude-from=", "--include-from=", "--filter=merge "} {
+			path := listArgument(rsync.cmd.Args, flag)
+			assert.Equal(t, os.TempDir(), filepath.Dir(path))
+			_, err := os.Stat(path)
+			assert.True(t, os.IsNotExist(err), "list file is removed after run")
+		}
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src/", dir, RsyncOptions{
+			FilesFrom: FileList{Lines: []string{"a.txt", "b.txt"}, Stdin: true},
+		}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, rsync.Run())
+		assert.Contains(t, rsync.cmd.Args, "--files-from=-")
+		assert.Equal(t, "a.txt\nb.txt\n", executor.stdin[0])
+	})
+
+	t.Run("from0", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src/", dir, RsyncOptions{
+			ExcludeFrom: FileList{Lines: []string{"new\nline", "colon:name"}},
+			From0:       true,
+		}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, rsync.Run())
+		assert.Contains(t, rsync.cmd.Args, "--from0")
+		assert.Equal(t, "new\nline\x00colon:name\x00", executor.files[0]["--exclude-from="])
+	})
+
+	t.Run("new line without from0", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src/", dir, RsyncOptions{
+			ExcludeFrom: FileList{Lines: []string{"new\nline"}},
+		}, CommandOptions{Executor: executor})
+
+		assert.Error(t, rsync.Run())
+		assert.Empty(t, executor.Commands())
+	})
+
+	t.Run("only one stdin list", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		rsync := NewRsyncCommand("src/", dir, RsyncOptions{
+			ExcludeFrom: FileList{Lines: []string{"a"}, Stdin: true},
+			IncludeFrom: FileList{Lines: []string{"b"}, Stdin: true},
+		}, CommandOptions{Executor: executor})
+
+		assert.Error(t, rsync.Run())
+		assert.Empty(t, executor.Commands())
+	})
+
+	t.Run("existing file is kept", func(t *testing.T) {
+		path := filepath.Join(dir, "excludes.txt")
+		assert.NoError(t, ioutil.WriteFile(path, []byte("*.tmp\n"), 0644))
+
+		rsync := NewRsyncCommand("src/", dir, RsyncOptions{
+			ExcludeFrom: FileList{Path: path},
+		}, CommandOptions{Executor: newListExecutor(FakeRun{})})
+
+		assert.NoError(t, rsync.Run())
+		assert.Contains(t, rsync.cmd.Args, "--exclude-from="+path)
+		assert.FileExists(t, path)
+	})
+
+	t.Run("task writes lists for every attempt", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{ExitCode: int(ExitTimeout)}, FakeRun{})
+		task := NewTaskCommand("src/", dir, RsyncOptions{
+			ExcludeFrom: FileList{Lines: []string{"*.tmp"}},
+		}, CommandOptions{Executor: executor})
+		task.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+		assert.NoError(t, task.Run())
+		assert.Len(t, executor.files, 2)
+		assert.Equal(t, executor.files[0], executor.files[1])
+
+		path := listArgument(task.GetArguments(), "--exclude-from=")
+		assert.Equal(t, path, listArgument(executor.Commands()[0].Args, "--exclude-from="))
+	})
+}
+
+func TestFileListArguments(t *testing.T) {
+	options := RsyncOptions{
+		FilesFrom:   FileList{Stdin: true, Lines: []string{"a.txt"}},
+		ExcludeFrom: FileList{Lines: []string{"*.tmp"}},
+		IncludeFrom: FileList{Reader: strings.NewReader("*.go\n")},
+		MergeFrom:   FileList{Lines: FilterRules{}.Exclude("*.log").Lines()},
+		Delete:      true,
+	}
+
+	args := GetArguments(options)
+	assert.Contains(t, args, "--files-from=-")
+	assert.NotEmpty(t, listArgument(args, "--exclude-from="))
+	assert.NotEmpty(t, listArgument(args, "--include-from="))
+	assert.NotEmpty(t, listArgument(args, "--filter=merge "))
+	assert.Empty(t, options.ExcludeFrom.path)
+
+	args, err := GetVersionArguments(options, Version{Release: "3.2.7", Major: 3, Minor: 2, Patch: 7})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, listArgument(args, "--exclude-from="))
+}
+
+func TestFilesFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("slice", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		task := NewTaskFiles("/data/", FileList{Lines: []string{"a/b.txt", "c.txt"}}, dir, RsyncOptions{Archive: true}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, task.Run())
+		assert.Equal(t, "a/b.txt\nc.txt\n", executor.files[0]["--files-from="])
+
+		args := executor.Commands()[0].Args
+		assert.Equal(t, []string{"/data/", dir}, args[len(args)-2:])
+		assert.NotContains(t, args, "--recursive")
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(dir, "files.txt")
+		assert.NoError(t, ioutil.WriteFile(path, []byte("a.txt\n"), 0644))
+
+		rsync := NewRsyncFiles("/data/", FileList{Path: path}, dir, RsyncOptions{}, CommandOptions{})
+		assert.Contains(t, rsync.cmd.Args, "--files-from="+path)
+	})
+
+	t.Run("reader is copied to temporary file", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		files := FileList{Reader: strings.NewReader("a.txt\x00b.txt\x00")}
+		rsync := NewRsyncFiles("/data/", files, dir, RsyncOptions{From0: true}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, rsync.Run())
+		assert.Equal(t, "a.txt\x00b.txt\x00", executor.files[0]["--files-from="])
+	})
+
+	t.Run("reader is streamed to stdin", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		files := FileList{Reader: strings.NewReader("a.txt\n"), Stdin: true}
+		rsync := NewRsyncFiles("/data/", files, dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, rsync.Run())
+		assert.Contains(t, rsync.cmd.Args, "--files-from=-")
+		assert.Equal(t, "a.txt\n", executor.stdin[0])
+	})
+
+	t.Run("seekable reader is rewound for retries", func(t *testing.T) {
+		reader := strings.NewReader("skipped\na.txt\n")
+		_, err := reader.Seek(int64(len("skipped\n")), io.SeekStart)
+		assert.NoError(t, err)
+
+		executor := newListExecutor(FakeRun{ExitCode: int(ExitTimeout)}, FakeRun{})
+		task := NewTaskFiles("/data/", FileList{Reader: reader, Stdin: true}, dir, RsyncOptions{}, CommandOptions{Executor: executor})
+		task.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+		assert.NoError(t, task.Run())
+		assert.Equal(t, []string{"a.txt\n", "a.txt\n"}, executor.stdin)
+	})
+
+	t.Run("reader can't be read twice", func(t *testing.T) {
+		executor := newListExecutor(FakeRun{})
+		files := FileList{Reader: io.MultiReader(strings.NewReader("a.txt\n"))}
+		rsync := NewRsyncFiles("/data/", files, dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, rsync.Run())
+		assert.Error(t, rsync.Run())
+		assert.Len(t, executor.Commands(), 1)
+	})
+
+	t.Run("no relative", func(t *testing.T) {
+		rsync := NewRsyncFiles("/data/", FileList{Lines: []string{"a/b.txt"}}, dir, RsyncOptions{
+			No: &RsyncOptions{Relative: true},
+		}, CommandOptions{})
+		assert.Contains(t, rsync.cmd.Args, "--no-relative")
+	})
+}
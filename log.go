@@ -0,0 +1,99 @@
+package grsync
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// LineBuffer is io.Writer which keeps only last lines written to it. It is
+// safe for concurrent use, so it can be read while rsync is running.
+type LineBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+// NewLineBuffer returns buffer which keeps last size lines
+func NewLineBuffer(size int) *LineBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &LineBuffer{lines: make([]string, size)}
+}
+
+// Write adds complete lines of p to the buffer, the rest is kept until the
+// line is completed
+func (b *LineBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := append(b.partial, p...)
+	for {
+		index := bytes.IndexByte(data, '\n')
+		if index < 0 {
+			break
+		}
+		b.add(string(data[:index]))
+		data = data[index+1:]
+	}
+	b.partial = append([]byte{}, data...)
+
+	return len(p), nil
+}
+
+func (b *LineBuffer) add(line string) {
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	b.full = b.full || b.next == 0
+}
+
+// Lines returns kept lines from the oldest to the newest
+func (b *LineBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string{}, b.lines[:b.next]...)
+	}
+	return append(append([]string{}, b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// String returns kept lines, each one ends with a new line
+func (b *LineBuffer) String() string {
+	lines := b.Lines()
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// logBuffer is default Task log, which keeps the whole output
+type logBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+// logString returns log kept by writer, writers like files keep nothing
+func logString(w io.Writer) string {
+	if stringer, ok := w.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return ""
+}
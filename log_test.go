@@ -0,0 +1,39 @@
+package grsync
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineBuffer(t *testing.T) {
+	t.Run("keeps last lines", func(t *testing.T) {
+		buffer := NewLineBuffer(3)
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(buffer, "line %d\n", i)
+		}
+
+		assert.Equal(t, []string{"line 3", "line 4", "line 5"}, buffer.Lines())
+		assert.Equal(t, "line 3\nline 4\nline 5\n", buffer.String())
+	})
+
+	t.Run("not full", func(t *testing.T) {
+		buffer := NewLineBuffer(3)
+		fmt.Fprint(buffer, "line 1\n")
+		assert.Equal(t, []string{"line 1"}, buffer.Lines())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		buffer := NewLineBuffer(0)
+		assert.Empty(t, buffer.Lines())
+		assert.Equal(t, "", buffer.String())
+	})
+
+	t.Run("joins partial writes", func(t *testing.T) {
+		buffer := NewLineBuffer(2)
+		fmt.Fprint(buffer, "first ha")
+		fmt.Fprint(buffer, "lf\nsecond\nthi")
+		assert.Equal(t, []string{"first half", "second"}, buffer.Lines())
+	})
+}
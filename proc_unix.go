@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package grsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminateSignal asks rsync to exit gracefully
+var terminateSignal os.Signal = syscall.SIGTERM
+
+// pauseSignal and resumeSignal stop and continue rsync and its children
+var (
+	pauseSignal  os.Signal = syscall.SIGSTOP
+	resumeSignal os.Signal = syscall.SIGCONT
+)
+
+// processAttr places rsync in its own process group, so signals reach the
+// remote shell (ssh) started by rsync as well
+func processAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcess sends signal to the process group, or to the process only if
+// it isn't a group leader. Nothing is sent once os/exec has reaped the
+// process, its pid and group id may belong to another process then.
+func signalProcess(process *os.Process, sig os.Signal) error {
+	if err := process.Signal(syscall.Signal(0)); err != nil {
+		return err
+	}
+	if signal, ok := sig.(syscall.Signal); ok {
+		if err := syscall.Kill(-process.Pid, signal); err == nil {
+			return nil
+		}
+	}
+	return process.Signal(sig)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package grsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminateSignal kills the process, windows has no graceful termination signal
+var terminateSignal = os.Kill
+
+// pauseSignal and resumeSignal are nil, windows processes can't be stopped
+var (
+	pauseSignal  os.Signal
+	resumeSignal os.Signal
+)
+
+func processAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func signalProcess(process *os.Process, sig os.Signal) error {
+	return process.Signal(sig)
+}
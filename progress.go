@@ -0,0 +1,126 @@
+package grsync
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progressLine is a single progress update printed by rsync:
+//
+//	999,999 99%  999.99kB/s    0:00:59 (xfr#9, to-chk=999/9999)
+//
+// With --info=progress2 bytes and percents relate to the whole transfer.
+type progressLine struct {
+	Bytes   int64
+	Percent int
+	// Speed is rate as printed by rsync, e.g. 999.99kB/s
+	Speed string
+	// Rate in bytes per second
+	Rate float64
+	// Time is remaining time, or elapsed time if Finished
+	Time time.Duration
+	// Finished is true for the last update of a file, which contains xfr#
+	Finished bool
+	// Files is the number of transferred files, set if Finished
+	Files int
+}
+
+var progressLineMatcher = newMatcher(`([\d,.']+[KMGTP]?)\s+(\d+)%\s+([\d,.']+[kKMGT]?i?B/s)\s+(\d+):(\d\d):(\d\d)(?: \(xfr#(\d+))?`)
+
+// parseProgressLine parses the last progress update in the line, there are
+// several of them if updates are separated with \r
+func parseProgressLine(line string) (progressLine, bool) {
+	const (
+		indexBytes = iota + 1
+		indexPercent
+		indexRate
+		indexHours
+		indexMinutes
+		indexSeconds
+		indexFiles
+	)
+
+	matches := progressLineMatcher.ExtractAllStringSubmatch(line, -1)
+	if len(matches) == 0 {
+		return progressLine{}, false
+	}
+	match := matches[len(matches)-1]
+
+	bytes, err := ParseSize(match[indexBytes])
+	if err != nil {
+		return progressLine{}, false
+	}
+
+	rate, err := ParseRate(match[indexRate])
+	if err != nil {
+		return progressLine{}, false
+	}
+
+	percent, _ := strconv.Atoi(match[indexPercent])
+	hours, _ := strconv.Atoi(match[indexHours])
+	minutes, _ := strconv.Atoi(match[indexMinutes])
+	seconds, _ := strconv.Atoi(match[indexSeconds])
+	files, _ := strconv.Atoi(match[indexFiles])
+
+	return progressLine{
+		Bytes:    bytes,
+		Percent:  percent,
+		Speed:    match[indexRate],
+		Rate:     rate,
+		Time:     time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second,
+		Finished: match[indexFiles] != "",
+		Files:    files,
+	}, true
+}
+
+// rsyncMessageMatcher matches informational stdout lines which aren't file names
+var rsyncMessageMatcher = newMatcher(`^((sending|receiving) (incremental )?file list|building file list|created directory |deleting |sent .+ bytes +received |total size is |delta-transmission |skipping |done$)`)
+
+// isFileNameLine reports whether line is a file name printed by rsync before
+// progress of the file. Directories are skipped, they have no progress.
+func isFileNameLine(line string) bool {
+	return line != "" && line[0] != ' ' && !strings.HasSuffix(line, "/") && !rsyncMessageMatcher.Match(line)
+}
+
+// scanLines is bufio.SplitFunc which splits rsync output by \n, \r\n and \r,
+// rsync separates progress updates of a single file with \r. A \r right
+// after another break doesn't make an empty line, rsync starts every progress
+// update with \r, also after the file name line.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	skipped := 0
+	for skipped+1 < len(data) && data[skipped] == '\r' && data[skipped+1] != '\n' {
+		skipped++
+	}
+
+	advance, token, err = scanLine(data[skipped:], atEOF)
+	if advance > 0 {
+		advance += skipped
+	}
+	return advance, token, err
+}
+
+// scanLine returns the first line of data
+func scanLine(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	index := bytes.IndexAny(data, "\r\n")
+	switch {
+	case index < 0 && atEOF:
+		return len(data), data, nil
+	case index < 0:
+		return 0, nil, nil
+	case data[index] == '\n':
+		return index + 1, data[:index], nil
+	case index+1 < len(data) && data[index+1] == '\n':
+		return index + 2, data[:index], nil
+	case index+1 < len(data) || atEOF:
+		return index + 1, data[:index], nil
+	}
+
+	// \r is the last byte, request more data to check if \n follows it
+	return 0, nil, nil
+}
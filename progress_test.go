@@ -0,0 +1,115 @@
+package grsync
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProgressLine(t *testing.T) {
+	t.Run("in progress", func(t *testing.T) {
+		progress, ok := parseProgressLine("  1,238,099,968  45%  118.04MB/s    0:00:10")
+		assert.True(t, ok)
+		assert.Equal(t, int64(1238099968), progress.Bytes)
+		assert.Equal(t, 45, progress.Percent)
+		assert.InDelta(t, 118.04*1024*1024, progress.Rate, 1)
+		assert.Equal(t, 10*time.Second, progress.Time)
+		assert.False(t, progress.Finished)
+	})
+
+	t.Run("finished file", func(t *testing.T) {
+		progress, ok := parseProgressLine("          1.05M 100%  659.30kB/s    1:02:03 (xfr#5, ir-chk=3641/3679)")
+		assert.True(t, ok)
+		assert.Equal(t, int64(1050000), progress.Bytes)
+		assert.Equal(t, 100, progress.Percent)
+		assert.InDelta(t, 659.30*1024, progress.Rate, 1)
+		assert.Equal(t, time.Hour+2*time.Minute+3*time.Second, progress.Time)
+		assert.True(t, progress.Finished)
+		assert.Equal(t, 5, progress.Files)
+	})
+
+	t.Run("last of several updates", func(t *testing.T) {
+		progress, ok := parseProgressLine("    32,768   0%    0.00kB/s    0:00:00\r  5,242,880  50%    5.00MB/s    0:00:01")
+		assert.True(t, ok)
+		assert.Equal(t, int64(5242880), progress.Bytes)
+		assert.Equal(t, 50, progress.Percent)
+	})
+
+	t.Run("not progress", func(t *testing.T) {
+		_, ok := parseProgressLine("sending incremental file list")
+		assert.False(t, ok)
+	})
+}
+
+func TestIsFileNameLine(t *testing.T) {
+	assert.True(t, isFileNameLine("file.txt"))
+	assert.True(t, isFileNameLine("dir/file with spaces.txt"))
+	assert.False(t, isFileNameLine(""))
+	assert.False(t, isFileNameLine("dir/"))
+	assert.False(t, isFileNameLine("          1.05M 100%  659.30kB/s    0:00:01 (xfr#5, ir-chk=3641/3679)"))
+	assert.False(t, isFileNameLine("sending incremental file list"))
+	assert.False(t, isFileNameLine("receiving file list ... done"))
+	assert.False(t, isFileNameLine("sent 1.46K bytes  received 73 bytes  3.06K bytes/sec"))
+	assert.False(t, isFileNameLine("total size is 1.23M  speedup is 0.81"))
+	assert.False(t, isFileNameLine("deleting old.txt"))
+}
+
+func TestScanLines(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("a\nb\r\nc\rd\r\re\n\rf\n\ng\r\n\r\nh\r"))
+	scanner.Split(scanLines)
+
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "", "g", "", "h"}, lines)
+}
+
+func TestScanLinesSplitRead(t *testing.T) {
+	// \r\n split between reads is a single line break
+	reader := iotest.OneByteReader(strings.NewReader("a\r\nb\n\rc\r"))
+	scanner := bufio.NewScanner(reader)
+	scanner.Split(scanLines)
+
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, lines)
+}
+
+func TestTaskCarriageReturnProgress(t *testing.T) {
+	output, err := os.Open(filepath.Join("testdata", "progress.txt"))
+	assert.NoError(t, err)
+	defer output.Close()
+
+	task := NewTask("a", "b", RsyncOptions{})
+
+	var states []State
+	task.OnProgress(func(state State) {
+		states = append(states, state)
+	})
+	processStdout(task, output)
+
+	filesProgress := []float64{}
+	for _, state := range states {
+		filesProgress = append(filesProgress, state.FileProgress)
+	}
+	assert.Equal(t, []float64{0, 6, 13, 50, 100, 100, 100}, filesProgress)
+
+	assert.Equal(t, "big.iso", states[3].File)
+	assert.Equal(t, int64(524288000), states[3].FileBytes)
+	assert.Equal(t, "100.00MB/s", states[3].Speed)
+	assert.Equal(t, 5*time.Second, states[3].ETA)
+	assert.Equal(t, "small.txt", states[6].File)
+	assert.Equal(t, float64(100), states[6].Progress)
+	assert.Equal(t, []string{"big.iso", "small.txt"}, task.CompletedFiles())
+}
@@ -0,0 +1,79 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const defaultBackoffMultiplier = 2
+
+// RetryPolicy describes how Task reruns failed transfers. Task forces Partial
+// option, so every attempt continues partially transferred files.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of runs, retries are disabled if it is less than 2
+	MaxAttempts int
+	// InitialBackoff is delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff limits delay growth, zero means no limit
+	MaxBackoff time.Duration
+	// Multiplier increases delay after every retry, 2 by default
+	Multiplier float64
+	// Jitter randomly changes delay by the fraction from 0 to 1
+	Jitter float64
+	// RetryableCodes are exit codes to retry, ExitCode.IsRetryable is used if empty
+	RetryableCodes []ExitCode
+}
+
+// isRetryable reports whether err should be retried
+func (p RetryPolicy) isRetryable(err error) bool {
+	var rsyncErr *RsyncError
+	if !errors.As(err, &rsyncErr) {
+		return false
+	}
+
+	if len(p.RetryableCodes) == 0 {
+		return rsyncErr.IsRetryable()
+	}
+
+	for _, code := range p.RetryableCodes {
+		if code == rsyncErr.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns delay before retry, retry starts from 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 {
+		delay = math.Min(delay, float64(p.MaxBackoff))
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * math.Min(p.Jitter, 1) * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// sleep waits for delay or until ctx is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Run("exponential", func(t *testing.T) {
+		policy := RetryPolicy{InitialBackoff: time.Second}
+		assert.Equal(t, time.Second, policy.backoff(1))
+		assert.Equal(t, 2*time.Second, policy.backoff(2))
+		assert.Equal(t, 4*time.Second, policy.backoff(3))
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		policy := RetryPolicy{InitialBackoff: time.Second, Multiplier: 3, MaxBackoff: 5 * time.Second}
+		assert.Equal(t, 3*time.Second, policy.backoff(2))
+		assert.Equal(t, 5*time.Second, policy.backoff(3))
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		policy := RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			delay := policy.backoff(1)
+			assert.True(t, delay >= 500*time.Millisecond && delay <= 1500*time.Millisecond)
+		}
+	})
+}
+
+func TestRetryPolicyIsRetryable(t *testing.T) {
+	assert.True(t, RetryPolicy{}.isRetryable(&RsyncError{Code: ExitTimeout}))
+	assert.False(t, RetryPolicy{}.isRetryable(&RsyncError{Code: ExitVanished}))
+	assert.False(t, RetryPolicy{}.isRetryable(fmt.Errorf("not rsync")))
+
+	policy := RetryPolicy{RetryableCodes: []ExitCode{ExitVanished}}
+	assert.True(t, policy.isRetryable(&RsyncError{Code: ExitVanished}))
+	assert.False(t, policy.isRetryable(&RsyncError{Code: ExitTimeout}))
+}
+
+func TestTaskRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	timeout := FakeRun{Stderr: "io timeout after 30 seconds\n", ExitCode: int(ExitTimeout)}
+
+	t.Run("retries until success", func(t *testing.T) {
+		executor := NewFakeExecutor(timeout, timeout, FakeRun{})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+		task.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+		assert.NoError(t, task.Run())
+		assert.Len(t, executor.Commands(), 3)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		executor := NewFakeExecutor(timeout)
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+		task.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+		err := task.Run()
+		assert.True(t, IsRetryable(err))
+		assert.Len(t, executor.Commands(), 2)
+	})
+
+	t.Run("doesn't retry other errors", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{ExitCode: int(ExitSyntax)})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+		task.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+		assert.Error(t, task.Run())
+		assert.Len(t, executor.Commands(), 1)
+	})
+
+	t.Run("stops waiting when context is done", func(t *testing.T) {
+		executor := NewFakeExecutor(timeout)
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+		task.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, task.RunContext(ctx))
+
+		state := task.State()
+		assert.Equal(t, StatusCancelled, state.Status)
+		assert.Equal(t, ExitTimeout, state.ExitCode)
+	})
+}
+
+func TestTaskRetryStderr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	executor := NewFakeExecutor(
+		FakeRun{Stderr: "first attempt failure\n", ExitCode: int(ExitTimeout)},
+		FakeRun{Stderr: "second attempt failure\n", ExitCode: int(ExitPartial)},
+	)
+	task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+	task.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+	var rsyncErr *RsyncError
+	if assert.True(t, errors.As(task.Run(), &rsyncErr)) {
+		assert.Equal(t, ExitPartial, rsyncErr.Code)
+		assert.Equal(t, "second attempt failure\n", rsyncErr.Stderr)
+	}
+	assert.Equal(t, "first attempt failure\nsecond attempt failure\n", task.Log().Stderr)
+}
@@ -1,20 +1,44 @@
 package grsync
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// defaultKillTimeout is how long a cancelled rsync gets to exit after SIGTERM
+// before it is killed
+const defaultKillTimeout = 10 * time.Second
+
 // Rsync is wrapper under rsync
 type Rsync struct {
+	// Source is the first of Sources
 	Source      string
+	Sources     []string
 	Destination string
 
-	cmd *exec.Cmd
+	cmd             *rsyncCommand
+	executor        Executor
+	killTimeout     time.Duration
+	destinationMode DestinationMode
+	// rsh is remote shell of rsync, it creates remote destination directory
+	rsh string
+	// lists are written before rsync starts and removed after it exits
+	lists []*FileList
+	from0 bool
+}
+
+// rsyncCommand is rsync command which can be started several times
+type rsyncCommand struct {
+	Command
+	// pipes are closed when rsync exits
+	pipes []*io.PipeWriter
 }
 
 // RsyncOptions for rsync
@@ -33,6 +57,10 @@ type RsyncOptions struct {
 	Relative bool
 	// NoImliedDirs don't send implied dirs with --relative
 	NoImpliedDirs bool
+	// NoIncRecursive disable incremental recursion, so the file list is complete before transfer
+	NoIncRecursive bool
+	// Mkpath create destination's missing path components
+	Mkpath bool
 	// Update skip files that are newer on the receiver
 	Update bool
 	// Inplace update destination files in-place
@@ -97,6 +125,8 @@ type RsyncOptions struct {
 	Rsh string
 	// RsyncProgramm rsync-path=PROGRAM specify the rsync to run on remote machine
 	RsyncProgramm string
+	// ProtectArgs no space-splitting; wildcard chars only
+	ProtectArgs bool
 	// Existing skip creating new files on receiver
 	Existing bool
 	// IgnoreExisting skip updating files that exist on receiver
@@ -171,6 +201,19 @@ type RsyncOptions struct {
 	Progress bool
 	// Info
 	Info string
+	// Filters --filter="", ordered filter rules. They are passed before
+	// Exclude, Include and Filter, so they take precedence.
+	Filters FilterRules
+	// FilesFrom --files-from=FILE read list of source-file names from FILE
+	FilesFrom FileList
+	// ExcludeFrom --exclude-from=FILE read exclude patterns from FILE
+	ExcludeFrom FileList
+	// IncludeFrom --include-from=FILE read include patterns from FILE
+	IncludeFrom FileList
+	// MergeFrom --filter="merge FILE" read filter rules from FILE
+	MergeFrom FileList
+	// From0 all *-from/filter files are delimited by 0s
+	From0 bool
 	// Exclude --exclude="", exclude remote paths.
 	Exclude []string
 	// Include --include="", include remote paths.
@@ -190,45 +233,343 @@ type RsyncOptions struct {
 
 	//out-format
 	OutFormat bool
+	// ItemizeChanges output a change-summary for all updates
+	ItemizeChanges bool
+
+	// outFormat replaces %n format of OutFormat, Task sets it to parse file events
+	outFormat string
 }
 
 // StdoutPipe returns a pipe that will be connected to the command's
 // standard output when the command starts.
 func (r Rsync) StdoutPipe() (io.ReadCloser, error) {
-	return r.cmd.StdoutPipe()
+	if r.cmd.Stdout != nil {
+		return nil, errors.New("grsync: Stdout already set")
+	}
+
+	reader, writer := io.Pipe()
+	r.cmd.Stdout = writer
+	r.cmd.pipes = append(r.cmd.pipes, writer)
+	return reader, nil
 }
 
 // StderrPipe returns a pipe that will be connected to the command's
 // standard error when the command starts.
 func (r Rsync) StderrPipe() (io.ReadCloser, error) {
-	return r.cmd.StderrPipe()
+	if r.cmd.Stderr != nil {
+		return nil, errors.New("grsync: Stderr already set")
+	}
+
+	reader, writer := io.Pipe()
+	r.cmd.Stderr = writer
+	r.cmd.pipes = append(r.cmd.pipes, writer)
+	return reader, nil
 }
 
 // Run start rsync task
 func (r Rsync) Run() error {
-	if !isExist(r.Destination) {
-		if err := createDir(r.Destination); err != nil {
-			return err
+	return r.RunContext(context.Background())
+}
+
+// RunContext starts rsync task and stops it when ctx is done. Stopped rsync
+// gets SIGTERM first and SIGKILL if it is still running after the kill
+// timeout. Returns ctx.Err() if the task was stopped and *RsyncError if rsync
+// exited with non-zero code.
+func (r Rsync) RunContext(ctx context.Context) error {
+	process, err := r.start(ctx)
+	if err != nil {
+		return err
+	}
+
+	return process.wait()
+}
+
+// process is started rsync, which is terminated when its context is done
+type process struct {
+	Process
+
+	ctx         context.Context
+	killTimeout time.Duration
+	// stderr is kept for RsyncError unless somebody reads stderr pipe
+	stderr *tailBuffer
+	pipes  []*io.PipeWriter
+
+	exited  chan struct{}
+	stopped chan bool
+	// cleanup removes files used by the process after it exits
+	cleanup func()
+
+	// mu guards fields below and signals, rsync isn't signalled after Wait
+	// returns, since its pid may be reused by another process
+	mu     sync.Mutex
+	waited bool
+	paused bool
+}
+
+// start starts rsync. Pipes are closed when rsync exits, so they must be read
+// concurrently with process.wait.
+func (r Rsync) start(ctx context.Context) (*process, error) {
+	if err := ctx.Err(); err != nil {
+		r.closePipes()
+		return nil, err
+	}
+
+	if len(r.Sources) == 0 {
+		r.closePipes()
+		return nil, ErrNoSources
+	}
+
+	if err := r.prepareDestination(ctx); err != nil {
+		r.closePipes()
+		return nil, err
+	}
+
+	// Pipes belong to this run, the next one requests new pipes
+	command, pipes := r.cmd.Command, r.cmd.pipes
+	r.cmd.Stdout, r.cmd.Stderr, r.cmd.pipes = nil, nil, nil
+
+	cleanup, err := writeLists(r.lists, r.from0, &command)
+	if err != nil {
+		for _, pipe := range pipes {
+			pipe.Close()
 		}
+		return nil, err
+	}
+
+	p, err := r.startProcess(ctx, command, pipes)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	p.cleanup = cleanup
+	return p, nil
+}
+
+// startProcess starts command which is terminated when ctx is done, pipes
+// are closed when it exits
+func (r Rsync) startProcess(ctx context.Context, command Command, pipes []*io.PipeWriter) (*process, error) {
+	p := &process{
+		ctx:         ctx,
+		killTimeout: r.killTimeout,
+		pipes:       pipes,
+		exited:      make(chan struct{}),
+		stopped:     make(chan bool, 1),
+	}
+	if p.killTimeout <= 0 {
+		p.killTimeout = defaultKillTimeout
+	}
+
+	if command.Stderr == nil {
+		p.stderr = &tailBuffer{size: stderrTailSize}
+		command.Stderr = p.stderr
+	}
+
+	started, err := r.executor.Start(command)
+	if err != nil {
+		p.closePipes()
+		return nil, err
+	}
+	p.Process = started
+
+	go p.watch()
+	return p, nil
+}
+
+// closePipes closes pipes of rsync which won't be started
+func (r Rsync) closePipes() {
+	for _, pipe := range r.cmd.pipes {
+		pipe.Close()
+	}
+	r.cmd.Stdout, r.cmd.Stderr, r.cmd.pipes = nil, nil, nil
+}
+
+// wait waits for rsync to exit
+func (p *process) wait() error {
+	err := p.Wait()
+	p.mu.Lock()
+	p.waited = true
+	p.mu.Unlock()
+	close(p.exited)
+	p.closePipes()
+	if p.cleanup != nil {
+		p.cleanup()
+	}
+	if <-p.stopped {
+		return p.ctx.Err()
+	}
+
+	if p.stderr != nil {
+		return newRsyncError(err, p.stderr.String())
+	}
+	return newRsyncError(err, "")
+}
+
+func (p *process) closePipes() {
+	for _, pipe := range p.pipes {
+		pipe.Close()
+	}
+}
+
+// watch terminates rsync when context is done before rsync exits. Exit wins
+// if both happen, so rsync which has already finished isn't reported as
+// cancelled.
+func (p *process) watch() {
+	select {
+	case <-p.exited:
+		p.stopped <- false
+		return
+	case <-p.ctx.Done():
+	}
+
+	select {
+	case <-p.exited:
+		p.stopped <- false
+	default:
+		p.stopped <- p.terminate()
+	}
+}
+
+// terminate sends SIGTERM to rsync and SIGKILL if it doesn't exit in time.
+// It reports false if rsync has already exited and wasn't signalled.
+func (p *process) terminate() bool {
+	running, err := p.signal(terminateSignal)
+	if !running {
+		return false
+	}
+	if err != nil {
+		p.signal(os.Kill)
+		return true
+	}
+
+	// Stopped rsync handles SIGTERM only after it is continued
+	p.resume()
+
+	timer := time.NewTimer(p.killTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.exited:
+	case <-timer.C:
+		p.signal(os.Kill)
 	}
+	return true
+}
 
-	if err := r.cmd.Start(); err != nil {
+// signal sends signal to rsync unless it has exited, it reports whether
+// rsync was still running. Wait may return much later than rsync exits,
+// since it waits until the output is read.
+func (p *process) signal(sig os.Signal) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.waited {
+		return false, nil
+	}
+	if err := p.Signal(sig); err != errProcessDone {
+		return true, err
+	}
+	return false, nil
+}
+
+// pause stops rsync and its children until resume
+func (p *process) pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused || p.waited {
+		return nil
+	}
+	switch err := p.Signal(pauseSignal); {
+	case err == errProcessDone:
+		return nil
+	case err != nil:
 		return err
 	}
+	p.paused = true
+	return nil
+}
 
-	return r.cmd.Wait()
+// resume continues paused rsync
+func (p *process) resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused || p.waited {
+		return nil
+	}
+	if err := p.Signal(resumeSignal); err != nil && err != errProcessDone {
+		return err
+	}
+	p.paused = false
+	return nil
 }
 
 // NewRsync returns task with described options
 func NewRsync(source, destination string, options RsyncOptions) *Rsync {
-	arguments := append(GetArguments(options), source, destination)
+	return NewRsyncCommand(source, destination, options, CommandOptions{})
+}
+
+// NewRsyncCommand returns task with described options which runs rsync
+// process configured by command options
+func NewRsyncCommand(source, destination string, options RsyncOptions, command CommandOptions) *Rsync {
+	return NewRsyncSources([]string{source}, destination, options, command)
+}
+
+// NewRsyncSources returns task which transfers several sources to the
+// destination with a single rsync process. Trailing slash of every source has
+// rsync meaning. Remote sources must be on the same host. Run fails with
+// ErrNoSources if sources are empty.
+func NewRsyncSources(sources []string, destination string, options RsyncOptions, command CommandOptions) *Rsync {
+	if command.DestinationMode == DestinationMkpath {
+		options.Mkpath = true
+	}
+	options.assignListPaths()
+
+	var source string
+	if len(sources) > 0 {
+		source = sources[0]
+	}
+
+	arguments := append(append(GetArguments(options), sources...), destination)
 	return &Rsync{
-		Source:      source,
-		Destination: destination,
-		cmd:         exec.Command("rsync", arguments...),
+		Source:          source,
+		Sources:         append([]string{}, sources...),
+		Destination:     destination,
+		cmd:             &rsyncCommand{Command: command.command(arguments)},
+		executor:        command.executor(),
+		killTimeout:     command.killTimeout(),
+		destinationMode: command.DestinationMode,
+		rsh:             options.Rsh,
+		lists:           options.generatedLists(),
+		from0:           options.From0,
 	}
 }
 
+// NewRsyncFiles returns task which transfers only files of the list with
+// --files-from instead of scanning the source. Paths of the list are relative
+// to the source directory. Rsync implies --relative, so the paths are kept in
+// the destination, --no-relative is set with No.Relative option. Rsync also
+// implies --dirs, and --archive doesn't recurse into listed directories,
+// Recursive option must be set explicitly for it.
+func NewRsyncFiles(source string, files FileList, destination string, options RsyncOptions, command CommandOptions) *Rsync {
+	options.FilesFrom = files
+	return NewRsyncCommand(source, destination, options, command)
+}
+
+// NewRsyncEndpoint returns task which transfers files between endpoints. Port
+// of ssh endpoint is passed to the remote shell, remote paths with spaces or
+// shell special characters are passed with --protect-args.
+func NewRsyncEndpoint(source, destination Endpoint, options RsyncOptions, command CommandOptions) *Rsync {
+	return NewRsyncEndpoints([]Endpoint{source}, destination, options, command)
+}
+
+// NewRsyncEndpoints returns task which transfers several source endpoints to
+// the destination with a single rsync process
+func NewRsyncEndpoints(sources []Endpoint, destination Endpoint, options RsyncOptions, command CommandOptions) *Rsync {
+	options = endpointOptions(options, sources, destination)
+	return NewRsyncSources(endpointStrings(sources), destination.String(), options, command)
+}
+
 func GetArguments(options RsyncOptions) []string {
 	args := GetArgsPrefix(options, "--")
 	if options.No != nil {
@@ -238,6 +579,11 @@ func GetArguments(options RsyncOptions) []string {
 }
 
 func GetArgsPrefix(options RsyncOptions, prefix string) []string {
+	// Constructors assign paths of generated lists, options passed here
+	// directly get them too, so list arguments aren't dropped. Such lists
+	// aren't written and rsync fails to read them instead of ignoring them.
+	options.assignListPaths()
+
 	arguments := []string{}
 	if options.Verbose {
 		arguments = append(arguments, fmt.Sprintf("%sverbose", prefix))
@@ -267,6 +613,14 @@ func GetArgsPrefix(options RsyncOptions, prefix string) []string {
 		arguments = append(arguments, fmt.Sprintf("%sno-implied-dirs", prefix))
 	}
 
+	if options.NoIncRecursive {
+		arguments = append(arguments, fmt.Sprintf("%sno-inc-recursive", prefix))
+	}
+
+	if options.Mkpath {
+		arguments = append(arguments, fmt.Sprintf("%smkpath", prefix))
+	}
+
 	if options.Update {
 		arguments = append(arguments, fmt.Sprintf("%supdate", prefix))
 	}
@@ -391,6 +745,10 @@ func GetArgsPrefix(options RsyncOptions, prefix string) []string {
 		arguments = append(arguments, fmt.Sprintf("%srsync-programm", prefix), options.RsyncProgramm)
 	}
 
+	if options.ProtectArgs {
+		arguments = append(arguments, fmt.Sprintf("%sprotect-args", prefix))
+	}
+
 	if options.Existing {
 		arguments = append(arguments, fmt.Sprintf("%sexisting", prefix))
 	}
@@ -547,8 +905,38 @@ func GetArgsPrefix(options RsyncOptions, prefix string) []string {
 		arguments = append(arguments, fmt.Sprintf("%sinfo", prefix), options.Info)
 	}
 
+	if options.ItemizeChanges {
+		arguments = append(arguments, fmt.Sprintf("%sitemize-changes", prefix))
+	}
+
 	if options.OutFormat {
-		arguments = append(arguments, fmt.Sprintf("%sout-format=\"%%n\"", prefix))
+		if options.outFormat != "" {
+			arguments = append(arguments, fmt.Sprintf("%sout-format=%s", prefix, options.outFormat))
+		} else {
+			arguments = append(arguments, fmt.Sprintf("%sout-format=\"%%n\"", prefix))
+		}
+	}
+
+	arguments = append(arguments, options.Filters.arguments(prefix)...)
+
+	if options.FilesFrom.argument() != "" {
+		arguments = append(arguments, fmt.Sprintf("%sfiles-from=%s", prefix, options.FilesFrom.argument()))
+	}
+
+	if options.ExcludeFrom.argument() != "" {
+		arguments = append(arguments, fmt.Sprintf("%sexclude-from=%s", prefix, options.ExcludeFrom.argument()))
+	}
+
+	if options.IncludeFrom.argument() != "" {
+		arguments = append(arguments, fmt.Sprintf("%sinclude-from=%s", prefix, options.IncludeFrom.argument()))
+	}
+
+	if options.MergeFrom.argument() != "" {
+		arguments = append(arguments, fmt.Sprintf("%sfilter=merge %s", prefix, options.MergeFrom.argument()))
+	}
+
+	if options.From0 {
+		arguments = append(arguments, fmt.Sprintf("%sfrom0", prefix))
 	}
 
 	if len(options.Exclude) > 0 {
@@ -573,16 +961,3 @@ func GetArgsPrefix(options RsyncOptions, prefix string) []string {
 
 	return arguments
 }
-
-func createDir(dir string) error {
-	cmd := exec.Command("mkdir", "-p", dir)
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-	return cmd.Wait()
-}
-
-func isExist(p string) bool {
-	stat, err := os.Stat(p)
-	return os.IsExist(err) && stat.IsDir()
-}
@@ -1,7 +1,14 @@
 package grsync
 
 import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -56,6 +63,13 @@ func TestParseArguments(t *testing.T) {
 		assert.Contains(t, args, "--no-implied-dirs")
 	})
 
+	t.Run("--no-inc-recursive", func(t *testing.T) {
+		args := GetArguments(RsyncOptions{
+			NoIncRecursive: true,
+		})
+		assert.Contains(t, args, "--no-inc-recursive")
+	})
+
 	t.Run("--update", func(t *testing.T) {
 		args := GetArguments(RsyncOptions{
 			Update: true,
@@ -273,6 +287,20 @@ func TestParseArguments(t *testing.T) {
 		assert.Contains(t, args, "--rsync-programm", "test")
 	})
 
+	t.Run("--mkpath", func(t *testing.T) {
+		args := GetArguments(RsyncOptions{
+			Mkpath: true,
+		})
+		assert.Contains(t, args, "--mkpath")
+	})
+
+	t.Run("--protect-args", func(t *testing.T) {
+		args := GetArguments(RsyncOptions{
+			ProtectArgs: true,
+		})
+		assert.Contains(t, args, "--protect-args")
+	})
+
 	t.Run("--existing", func(t *testing.T) {
 		args := GetArguments(RsyncOptions{
 			Existing: true,
@@ -578,4 +606,215 @@ func TestParseArguments(t *testing.T) {
 		})
 		assert.Contains(t, args, "--ipv6")
 	})
+
+	t.Run("--itemize-changes", func(t *testing.T) {
+		args := GetArguments(RsyncOptions{
+			ItemizeChanges: true,
+		})
+		assert.Contains(t, args, "--itemize-changes")
+	})
+
+	t.Run("--out-format", func(t *testing.T) {
+		args := GetArguments(RsyncOptions{
+			OutFormat: true,
+		})
+		assert.Contains(t, args, "--out-format=\"%n\"")
+	})
+}
+
+func TestRsyncRunContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires unix signals")
+	}
+
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("cancelled context stops process", func(t *testing.T) {
+		rsync := NewRsync("a", dir, RsyncOptions{})
+		useCommand(rsync, "sleep", "10")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		started := time.Now()
+		err := rsync.RunContext(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.True(t, time.Since(started) < 5*time.Second)
+	})
+
+	t.Run("process ignoring SIGTERM is killed", func(t *testing.T) {
+		rsync := NewRsync("a", dir, RsyncOptions{})
+		useCommand(rsync, "sh", "-c", `trap "" TERM; sleep 10`)
+		rsync.killTimeout = 100 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+
+		started := time.Now()
+		err := rsync.RunContext(ctx)
+		assert.Equal(t, context.Canceled, err)
+		assert.True(t, time.Since(started) < 5*time.Second)
+	})
+
+	t.Run("already cancelled context doesn't start process", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.Equal(t, context.Canceled, rsync.RunContext(ctx))
+		assert.Empty(t, executor.Commands())
+	})
+
+	t.Run("failed process returns RsyncError", func(t *testing.T) {
+		rsync := NewRsync("a", dir, RsyncOptions{})
+		useCommand(rsync, "sh", "-c", "echo 'file has vanished' >&2; exit 24")
+
+		err := rsync.RunContext(context.Background())
+		rsyncErr, ok := err.(*RsyncError)
+		assert.True(t, ok)
+		assert.Equal(t, ExitVanished, rsyncErr.Code)
+		assert.Equal(t, "file has vanished\n", rsyncErr.Stderr)
+		assert.True(t, IsPartial(err))
+	})
+}
+
+func TestProcessExited(t *testing.T) {
+	t.Run("exit wins over cancelled context", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		started, err := executor.Start(Command{})
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		p := &process{Process: started, ctx: ctx, exited: make(chan struct{}), stopped: make(chan bool, 1)}
+		p.waited = true
+		close(p.exited)
+
+		p.watch()
+		assert.False(t, <-p.stopped)
+		assert.Empty(t, executor.Signals())
+	})
+
+	t.Run("exited process isn't signalled", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncCommand("a", "b", RsyncOptions{}, CommandOptions{
+			DestinationMode: DestinationNone,
+			Executor:        executor,
+		})
+
+		p, err := rsync.start(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, p.wait())
+
+		assert.NoError(t, p.pause())
+		assert.NoError(t, p.resume())
+		assert.False(t, p.terminate())
+		assert.Empty(t, executor.Signals())
+	})
+}
+
+func TestProcessExitedBeforeOutputIsRead(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rsync := NewRsync("a", dir, RsyncOptions{})
+	useCommand(rsync, "sh", "-c", `echo first; head -c 20000 /dev/zero | tr "\\0" x; exit 0`)
+	stdout, err := rsync.StdoutPipe()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := rsync.start(ctx)
+	assert.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- p.wait() }()
+
+	reader := bufio.NewReader(stdout)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	// rsync exits, while Wait waits until the rest of the output is read
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, p.pause())
+	assert.NoError(t, p.resume())
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	io.Copy(ioutil.Discard, reader)
+	assert.NoError(t, <-done)
+}
+
+// useCommand replaces rsync with another program, e.g. a shell script
+func useCommand(rsync *Rsync, name string, arguments ...string) {
+	rsync.cmd = &rsyncCommand{Command: Command{
+		Path:        name,
+		Args:        arguments,
+		SysProcAttr: processAttr(),
+	}}
+}
+
+func TestNewRsyncSources(t *testing.T) {
+	t.Run("sources precede destination", func(t *testing.T) {
+		rsync := NewRsyncSources([]string{"project-a/", "project-b", "notes.txt"}, "backup/", RsyncOptions{Archive: true}, CommandOptions{})
+
+		assert.Equal(t, "project-a/", rsync.Source)
+		assert.Equal(t, []string{"project-a/", "project-b", "notes.txt"}, rsync.Sources)
+		assert.Equal(t, []string{"--archive", "project-a/", "project-b", "notes.txt", "backup/"}, rsync.cmd.Args)
+	})
+
+	t.Run("single source", func(t *testing.T) {
+		rsync := NewRsync("a", "b", RsyncOptions{})
+		assert.Equal(t, []string{"a"}, rsync.Sources)
+	})
+
+	t.Run("remote endpoints", func(t *testing.T) {
+		sources := []Endpoint{
+			{Kind: EndpointSSH, User: "user", Host: "host", Port: 2222, Path: "/srv/a/"},
+			{Kind: EndpointSSH, User: "user", Host: "host", Port: 2222, Path: "/srv/b"},
+		}
+		rsync := NewRsyncEndpoints(sources, LocalEndpoint("backup"), RsyncOptions{}, CommandOptions{})
+
+		assert.Equal(t, []string{"--rsh", "ssh -p 2222", "user@host:/srv/a/", "user@host:/srv/b", "backup"}, rsync.cmd.Args)
+	})
+
+	t.Run("task runs a single rsync", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "grsync")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		executor := NewFakeExecutor(FakeRun{})
+		task := NewTaskSources([]string{"a/", "b/"}, dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		assert.NoError(t, task.Run())
+		if assert.Len(t, executor.Commands(), 1) {
+			args := executor.Commands()[0].Args
+			assert.Equal(t, []string{"a/", "b/", dir}, args[len(args)-3:])
+		}
+	})
+}
+
+func TestRsyncNoSources(t *testing.T) {
+	for _, sources := range [][]string{nil, {}} {
+		executor := NewFakeExecutor(FakeRun{})
+		rsync := NewRsyncSources(sources, "backup/", RsyncOptions{}, CommandOptions{Executor: executor})
+		assert.Equal(t, ErrNoSources, rsync.Run())
+
+		task := NewTaskSources(sources, "backup/", RsyncOptions{}, CommandOptions{Executor: executor})
+		assert.Equal(t, ErrNoSources, task.Run())
+		assert.Equal(t, StatusFailed, task.State().Status)
+
+		assert.Empty(t, executor.Commands())
+	}
 }
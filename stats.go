@@ -0,0 +1,95 @@
+package grsync
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats contains transfer summary printed by rsync with --stats option
+type Stats struct {
+	Files                    int           `json:"files"`
+	CreatedFiles             int           `json:"createdFiles"`
+	DeletedFiles             int           `json:"deletedFiles"`
+	TransferredFiles         int           `json:"transferredFiles"`
+	TotalFileSize            int64         `json:"totalFileSize"`
+	TotalTransferredFileSize int64         `json:"totalTransferredFileSize"`
+	LiteralData              int64         `json:"literalData"`
+	MatchedData              int64         `json:"matchedData"`
+	FileListSize             int64         `json:"fileListSize"`
+	FileListGenerationTime   time.Duration `json:"fileListGenerationTime"`
+	FileListTransferTime     time.Duration `json:"fileListTransferTime"`
+	BytesSent                int64         `json:"bytesSent"`
+	BytesReceived            int64         `json:"bytesReceived"`
+	Speedup                  float64       `json:"speedup"`
+}
+
+// speedupMatcher matches the summary line, speedup has thousands separators
+// of the locale like other numbers, e.g. "speedup is 12,345.67"
+var speedupMatcher = newMatcher(`^total size is .+ speedup is (\d[\d,.']*)`)
+
+// parseLine fills stats from a line of --stats output. Returns false if
+// the line isn't a part of stats.
+func (s *Stats) parseLine(line string) bool {
+	if speedupMatcher.Match(line) {
+		s.Speedup, _ = parseNumber(speedupMatcher.Extract(line), decimalUnitBase)
+		return true
+	}
+
+	separator := strings.Index(line, ": ")
+	if separator < 0 {
+		return false
+	}
+
+	// Values look like "3 (reg: 2, dir: 1)", "1.23K bytes" or "0.001 seconds"
+	key := line[:separator]
+	fields := strings.Fields(line[separator+2:])
+	if len(fields) == 0 {
+		return false
+	}
+	value := fields[0]
+
+	switch key {
+	case "Number of files":
+		s.Files = parseCount(value)
+	case "Number of created files":
+		s.CreatedFiles = parseCount(value)
+	case "Number of deleted files":
+		s.DeletedFiles = parseCount(value)
+	case "Number of regular files transferred", "Number of files transferred":
+		s.TransferredFiles = parseCount(value)
+	case "Total file size":
+		s.TotalFileSize, _ = ParseSize(value)
+	case "Total transferred file size":
+		s.TotalTransferredFileSize, _ = ParseSize(value)
+	case "Literal data":
+		s.LiteralData, _ = ParseSize(value)
+	case "Matched data":
+		s.MatchedData, _ = ParseSize(value)
+	case "File list size":
+		s.FileListSize, _ = ParseSize(value)
+	case "File list generation time":
+		s.FileListGenerationTime = parseSeconds(value)
+	case "File list transfer time":
+		s.FileListTransferTime = parseSeconds(value)
+	case "Total bytes sent":
+		s.BytesSent, _ = ParseSize(value)
+	case "Total bytes received":
+		s.BytesReceived, _ = ParseSize(value)
+	default:
+		return false
+	}
+
+	return true
+}
+
+func parseCount(value string) int {
+	count, _ := ParseSize(value)
+	return int(count)
+}
+
+func parseSeconds(value string) time.Duration {
+	// Decimal separator depends on locale
+	seconds, _ := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,81 @@
+package grsync
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const statsOutput = `
+Number of files: 3 (reg: 2, dir: 1)
+Number of created files: 3 (reg: 2, dir: 1)
+Number of deleted files: 1 (reg: 1)
+Number of regular files transferred: 2
+Total file size: 1,234,567 bytes
+Total transferred file size: 1,234 bytes
+Literal data: 1,034 bytes
+Matched data: 200 bytes
+File list size: 0
+File list generation time: 0.001 seconds
+File list transfer time: 0.000 seconds
+Total bytes sent: 1,461
+Total bytes received: 73
+
+sent 1,461 bytes  received 73 bytes  3,068.00 bytes/sec
+total size is 1,234,567  speedup is 0.81
+`
+
+func TestStatsParse(t *testing.T) {
+	stats := Stats{}
+	for _, line := range strings.Split(statsOutput, "\n") {
+		stats.parseLine(line)
+	}
+
+	assert.Equal(t, Stats{
+		Files:                    3,
+		CreatedFiles:             3,
+		DeletedFiles:             1,
+		TransferredFiles:         2,
+		TotalFileSize:            1234567,
+		TotalTransferredFileSize: 1234,
+		LiteralData:              1034,
+		MatchedData:              200,
+		FileListGenerationTime:   time.Millisecond,
+		BytesSent:                1461,
+		BytesReceived:            73,
+		Speedup:                  0.81,
+	}, stats)
+}
+
+func TestStatsSpeedup(t *testing.T) {
+	testCases := map[string]float64{
+		"total size is 1,234,567  speedup is 0.81":             0.81,
+		"total size is 1,234,567,890  speedup is 1,000.00":     1000,
+		"total size is 1,234,567,890  speedup is 12,345.67":    12345.67,
+		"total size is 1.234.567.890  speedup is 12.345,67":    12345.67,
+		"total size is 1'234'567'890  speedup is 1'234'567.89": 1234567.89,
+		"total size is 1234567890  speedup is 12345.67":        12345.67,
+	}
+
+	for line, speedup := range testCases {
+		stats := Stats{}
+		assert.True(t, stats.parseLine(line), line)
+		assert.Equal(t, speedup, stats.Speedup, line)
+	}
+}
+
+func TestTaskStats(t *testing.T) {
+	task := NewTask("a", "b", RsyncOptions{Stats: true})
+	processStdout(task, strings.NewReader(statsOutput))
+
+	stats := task.Stats()
+	assert.Equal(t, 3, stats.Files)
+	assert.Equal(t, int64(73), stats.BytesReceived)
+	assert.Equal(t, 0.81, stats.Speedup)
+
+	task = NewTask("a", "b", RsyncOptions{})
+	processStdout(task, strings.NewReader(statsOutput))
+	assert.Empty(t, task.Stats())
+}
@@ -2,27 +2,104 @@ package grsync
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Task is high-level API under rsync
+// Task is high-level API under rsync. State and Log are safe to call
+// concurrently with Run.
 type Task struct {
 	rsync   *Rsync
 	options RsyncOptions
+	// progress2 reports progress of the whole transfer instead of files
+	progress2 bool
+	started   time.Time
 
-	state *State
-	log   *Log
+	// mu guards fields below, which are used by output processing
+	mu         sync.RWMutex
+	state      *State
+	stats      *Stats
+	onProgress []func(State)
+	onFile     []func(FileEvent)
+	// stdout and stderr receive rsync output, stderrTail is kept for errors
+	stdout     io.Writer
+	stderr     io.Writer
+	stderrTail *tailBuffer
+	// completed files, fileCompleted is true if the current file is among them
+	completed      []string
+	completedLimit int
+	fileCompleted  bool
+	// running is true during RunContext, process is the current attempt
+	running bool
+	process *process
+
+	retryPolicy RetryPolicy
 }
 
+// DefaultCompletedFilesLimit is how many last completed files Task keeps
+const DefaultCompletedFilesLimit = 1000
+
+// Status is lifecycle status of Task
+type Status string
+
+const (
+	// StatusPending task hasn't been run yet
+	StatusPending Status = "pending"
+	// StatusRunning task is running, including retry backoff and pause
+	StatusRunning Status = "running"
+	// StatusSucceeded rsync has finished successfully
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed rsync has failed or couldn't be started
+	StatusFailed Status = "failed"
+	// StatusCancelled task was stopped by its context
+	StatusCancelled Status = "cancelled"
+)
+
 // State contains information about rsync process
 type State struct {
 	Remain   int     `json:"remain"`
 	Total    int     `json:"total"`
 	Speed    string  `json:"speed"`
 	Progress float64 `json:"progress"`
+
+	// Transferred bytes of the whole transfer, set with --info=progress2
+	Transferred int64 `json:"transferred"`
+	// FileBytes is transferred bytes of the current file
+	FileBytes int64 `json:"fileBytes"`
+	// FileProgress is percent of the current file
+	FileProgress float64 `json:"fileProgress"`
+	// Rate is Speed in bytes per second
+	Rate float64 `json:"rate"`
+	// ETA is estimated remaining time of the current file, or of the whole
+	// transfer with --info=progress2
+	ETA time.Duration `json:"eta"`
+	// Elapsed is time since rsync has started
+	Elapsed time.Duration `json:"elapsed"`
+	// Files is the number of transferred files
+	Files int `json:"files"`
+	// File is path of the file being transferred. File names are known only
+	// after transfer with OutFormat, so it is the last transferred file then.
+	File string `json:"file"`
+	// Paused is true if rsync is stopped by Task.Pause
+	Paused bool `json:"paused"`
+
+	Status     Status    `json:"status"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// ExitCode is rsync exit code of the last attempt, it is set when the
+	// task is finished, -1 if rsync was stopped by a signal, -2 if rsync
+	// didn't exit, e.g. it couldn't be started
+	ExitCode ExitCode `json:"exitCode"`
+	// Error is the final error message of a failed or cancelled task
+	Error string `json:"error"`
 }
 
 // Log contains raw stderr and stdout outputs
@@ -32,89 +109,488 @@ type Log struct {
 }
 
 // State returns inforation about rsync processing task
-func (t Task) State() State {
+func (t *Task) State() State {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return *t.state
 }
 
 // Log return structure which contains raw stderr and stdout outputs
-func (t Task) Log() Log {
+func (t *Task) Log() Log {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return Log{
-		Stderr: t.log.Stderr,
-		Stdout: t.log.Stdout,
+		Stderr: logString(t.stderr),
+		Stdout: logString(t.stdout),
+	}
+}
+
+// SetLogOutput replaces default log, which keeps the whole rsync output in
+// memory. Output is written line by line, e.g. to a file or a LineBuffer,
+// nil writer discards the output. Log returns output only of writers which
+// implement fmt.Stringer. It must be called before Run.
+func (t *Task) SetLogOutput(stdout, stderr io.Writer) {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stdout, t.stderr = stdout, stderr
+}
+
+// Stats returns transfer summary, it is filled only if Stats option is set
+// and rsync has finished
+func (t *Task) Stats() Stats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return *t.stats
+}
+
+// CompletedFiles returns paths of the last files which rsync has finished
+// transferring, at most DefaultCompletedFilesLimit unless it is changed by
+// SetCompletedFilesLimit. State.Files is the number of all transferred files,
+// OnProgress and OnFile handlers receive every file.
+func (t *Task) CompletedFiles() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	completed := t.completed
+	if len(completed) > t.completedLimit {
+		completed = completed[len(completed)-t.completedLimit:]
+	}
+	return append([]string{}, completed...)
+}
+
+// SetCompletedFilesLimit sets how many last completed files are kept, 0
+// disables the history. It must be called before Run.
+func (t *Task) SetCompletedFilesLimit(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.completedLimit = limit
+	t.completed = nil
+}
+
+// OnProgress registers handler which is called with a state snapshot every
+// time rsync reports progress. Handlers are called from the goroutine reading
+// rsync output, so they should return quickly.
+func (t *Task) OnProgress(handler func(State)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onProgress = append(t.onProgress, handler)
+}
+
+// OnFile registers handler which is called for every file touched by rsync.
+// File events are reported only if OutFormat or ItemizeChanges option is set.
+// Handlers are called from the goroutine reading rsync output.
+func (t *Task) OnFile(handler func(FileEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onFile = append(t.onFile, handler)
+}
+
+// SetRetryPolicy sets how failed transfers are retried, it must be called
+// before Run
+func (t *Task) SetRetryPolicy(policy RetryPolicy) {
+	t.retryPolicy = policy
+}
+
+// Pause stops rsync process group until Resume without losing progress of
+// the transfer. Pause during retry backoff pauses the next attempt.
+func (t *Task) Pause() error {
+	if pauseSignal == nil {
+		return ErrPauseUnsupported
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.running {
+		return ErrNotRunning
 	}
+	if t.process != nil {
+		if err := t.process.pause(); err != nil {
+			return err
+		}
+	}
+	t.state.Paused = true
+	return nil
 }
 
-func (t Task) GetArguments() []string {
+// Resume continues rsync stopped by Pause
+func (t *Task) Resume() error {
+	if resumeSignal == nil {
+		return ErrPauseUnsupported
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.running {
+		return ErrNotRunning
+	}
+	if t.process != nil {
+		if err := t.process.resume(); err != nil {
+			return err
+		}
+	}
+	t.state.Paused = false
+	return nil
+}
+
+// GetArguments returns rsync arguments built from task options
+func (t *Task) GetArguments() []string {
 	return GetArguments(t.options)
 }
 
 // Run starts rsync process with options
 func (t *Task) Run() error {
+	return t.RunContext(context.Background())
+}
+
+// RunContext starts rsync process with options and stops it when ctx is done.
+// Failed transfer is restarted according to the retry policy.
+func (t *Task) RunContext(ctx context.Context) error {
+	// State of the previous run is dropped, so it doesn't mix with this one
+	t.mu.Lock()
+	t.started = time.Now()
+	t.running = true
+	*t.state = State{Status: StatusRunning, StartedAt: t.started}
+	*t.stats = Stats{}
+	t.completed = nil
+	t.fileCompleted = false
+	t.mu.Unlock()
+
+	exitCode, err := t.runAttempts(ctx)
+	t.finish(ctx, exitCode, err)
+	return err
+}
+
+// runAttempts runs rsync until it succeeds or retries are exhausted, it
+// returns exit code of the last attempt
+func (t *Task) runAttempts(ctx context.Context) (ExitCode, error) {
+	for attempt := 1; ; attempt++ {
+		exitCode, err := t.run(ctx)
+		if err == nil || attempt >= t.retryPolicy.MaxAttempts || !t.retryPolicy.isRetryable(err) {
+			return exitCode, err
+		}
+
+		if err := sleep(ctx, t.retryPolicy.backoff(attempt)); err != nil {
+			return exitCode, err
+		}
+	}
+}
+
+// finish records the result of RunContext in state
+func (t *Task) finish(ctx context.Context, exitCode ExitCode, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.running = false
+	t.state.Paused = false
+	t.state.FinishedAt = time.Now()
+	t.state.Elapsed = t.state.FinishedAt.Sub(t.started)
+
+	switch {
+	case err == nil:
+		t.state.Status = StatusSucceeded
+	case err == ctx.Err():
+		t.state.Status = StatusCancelled
+	default:
+		t.state.Status = StatusFailed
+	}
+	t.state.ExitCode = exitCode
+	if err != nil {
+		t.state.Error = err.Error()
+	}
+}
+
+// run runs rsync once and returns its exit code
+func (t *Task) run(ctx context.Context) (ExitCode, error) {
 	stderr, err := t.rsync.StderrPipe()
 	if err != nil {
-		return err
+		return exitCodeNotExited, err
 	}
 	defer stderr.Close()
 
 	stdout, err := t.rsync.StdoutPipe()
 	if err != nil {
-		return err
+		t.rsync.closePipes()
+		return exitCodeNotExited, err
 	}
 	defer stdout.Close()
 
-	go processStdout(t, stdout)
-	go processStderr(t, stderr)
+	process, err := t.rsync.start(ctx)
+	if err != nil {
+		return exitCodeNotExited, err
+	}
+
+	// Every attempt has its own stderr tail, so RsyncError contains only
+	// stderr of the failed rsync
+	stderrTail := &tailBuffer{size: stderrTailSize}
+
+	t.mu.Lock()
+	t.process = process
+	t.stderrTail = stderrTail
+	if t.state.Paused {
+		process.pause()
+	}
+	t.mu.Unlock()
+
+	// Pipes are closed when rsync exits, output is processed completely
+	// when both goroutines are done
+	var stdoutErr, stderrErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stdoutErr = processStdout(t, stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		stderrErr = processStderr(t, stderr)
+	}()
 
-	return t.rsync.Run()
+	err = process.wait()
+
+	t.mu.Lock()
+	t.process = nil
+	t.mu.Unlock()
+
+	wg.Wait()
+
+	// wait returns ctx.Err() only if rsync was terminated
+	exitCode := ExitSuccess
+	var rsyncErr *RsyncError
+	switch {
+	case errors.As(err, &rsyncErr):
+		exitCode = rsyncErr.Code
+		if rsyncErr.Stderr == "" {
+			rsyncErr.Stderr = stderrTail.String()
+		}
+	case err != nil && err == ctx.Err():
+		exitCode = exitCodeKilledBySignal
+	case err != nil:
+		exitCode = exitCodeNotExited
+	}
+
+	switch {
+	case err != nil:
+		return exitCode, err
+	case stdoutErr != nil:
+		return exitCode, fmt.Errorf("reading rsync stdout: %w", stdoutErr)
+	case stderrErr != nil:
+		return exitCode, fmt.Errorf("reading rsync stderr: %w", stderrErr)
+	}
+	return exitCode, nil
 }
 
 // NewTask returns new rsync task
 func NewTask(source, destination string, rsyncOptions RsyncOptions) *Task {
-	// Force set required options
-	rsyncOptions.HumanReadable = true
+	return NewTaskCommand(source, destination, rsyncOptions, CommandOptions{})
+}
+
+// NewTaskCommand returns new rsync task which runs rsync process configured
+// by command options
+func NewTaskCommand(source, destination string, rsyncOptions RsyncOptions, command CommandOptions) *Task {
+	return NewTaskSources([]string{source}, destination, rsyncOptions, command)
+}
+
+// NewTaskSources returns new rsync task which transfers several sources to
+// the destination with a single rsync process and a single progress stream
+func NewTaskSources(sources []string, destination string, rsyncOptions RsyncOptions, command CommandOptions) *Task {
+	// Force set required options. With --info=progress2 rsync reports progress
+	// of the whole transfer, which is meaningful only with complete file list.
+	// HumanReadable isn't forced: rsync 3.1+ prints exact comma-grouped sizes
+	// by default, while human-readable ones keep only 3 significant digits.
+	progress2 := strings.Contains(rsyncOptions.Info, "progress2")
 	rsyncOptions.Partial = true
-	rsyncOptions.Progress = true
+	rsyncOptions.Progress = !progress2
+	rsyncOptions.NoIncRecursive = rsyncOptions.NoIncRecursive || progress2
+	if rsyncOptions.OutFormat || rsyncOptions.ItemizeChanges {
+		rsyncOptions.OutFormat = true
+		rsyncOptions.outFormat = fileEventFormat
+	}
+	// Task arguments contain the same temporary list paths as rsync
+	rsyncOptions.assignListPaths()
 
 	return &Task{
-		rsync:   NewRsync(source, destination, rsyncOptions),
-		options: rsyncOptions,
-		state:   &State{},
-		log:     &Log{},
+		rsync:      NewRsyncSources(sources, destination, rsyncOptions, command),
+		options:    rsyncOptions,
+		progress2:  progress2,
+		state:      &State{Status: StatusPending},
+		stdout:     &logBuffer{},
+		stderr:     &logBuffer{},
+		stderrTail: &tailBuffer{size: stderrTailSize},
+		stats:      &Stats{},
+
+		completedLimit: DefaultCompletedFilesLimit,
 	}
 }
 
-func processStdout(task *Task, stdout io.Reader) {
+// NewTaskFiles returns new rsync task which transfers only files of the list
+// with --files-from, see NewRsyncFiles
+func NewTaskFiles(source string, files FileList, destination string, rsyncOptions RsyncOptions, command CommandOptions) *Task {
+	rsyncOptions.FilesFrom = files
+	return NewTaskCommand(source, destination, rsyncOptions, command)
+}
+
+// NewTaskEndpoint returns new rsync task which transfers files between
+// endpoints. Port of ssh endpoint is passed to the remote shell, remote paths
+// with spaces or shell special characters are passed with --protect-args.
+func NewTaskEndpoint(source, destination Endpoint, rsyncOptions RsyncOptions, command CommandOptions) *Task {
+	return NewTaskEndpoints([]Endpoint{source}, destination, rsyncOptions, command)
+}
+
+// NewTaskEndpoints returns new rsync task which transfers several source
+// endpoints to the destination with a single rsync process
+func NewTaskEndpoints(sources []Endpoint, destination Endpoint, rsyncOptions RsyncOptions, command CommandOptions) *Task {
+	rsyncOptions = endpointOptions(rsyncOptions, sources, destination)
+	return NewTaskSources(endpointStrings(sources), destination.String(), rsyncOptions, command)
+}
+
+// processStdout parses rsync stdout until EOF. Output is drained after
+// scanner errors, so rsync doesn't block on writing.
+func processStdout(task *Task, stdout io.Reader) error {
 	const maxPercents = float64(100)
 	const minDivider = 1
 
 	progressMatcher := newMatcher(`\(.+-chk=(\d+.\d+)`)
-	speedMatcher := newMatcher(`(\d+\.\d+.{2}\/s)`)
 
 	// Extract data from strings:
 	//         999,999 99%  999.99kB/s    0:00:59 (xfr#9, to-chk=999/9999)
+	task.mu.RLock()
+	output := task.stdout
+	task.mu.RUnlock()
+
 	scanner := bufio.NewScanner(stdout)
+	scanner.Split(scanLines)
 	for scanner.Scan() {
 		logStr := scanner.Text()
-		if progressMatcher.Match(logStr) {
+
+		progress, isTransfer := parseProgressLine(logStr)
+		event, isEvent := parseFileEvent(logStr)
+
+		isFileCount := progressMatcher.Match(logStr)
+		isProgress := isFileCount || isTransfer
+
+		task.mu.Lock()
+		if isFileCount {
 			task.state.Remain, task.state.Total = getTaskProgress(progressMatcher.Extract(logStr))
 
 			copiedCount := float64(task.state.Total - task.state.Remain)
 			task.state.Progress = copiedCount / math.Max(float64(task.state.Total), float64(minDivider)) * maxPercents
 		}
 
-		if speedMatcher.Match(logStr) {
-			task.state.Speed = getTaskSpeed(speedMatcher.ExtractAllStringSubmatch(logStr, 2))
+		if isTransfer {
+			if task.progress2 {
+				task.state.Transferred = progress.Bytes
+				task.state.Progress = float64(progress.Percent)
+			} else {
+				task.state.FileBytes = progress.Bytes
+				task.state.FileProgress = float64(progress.Percent)
+			}
+
+			// Time of the last file update is elapsed time instead of remaining
+			task.state.Speed = progress.Speed
+			task.state.Rate = progress.Rate
+			if progress.Finished {
+				task.state.Files = progress.Files
+				if !task.progress2 || progress.Percent == 100 {
+					task.state.ETA = 0
+				}
+				task.completeFile()
+			} else {
+				task.state.ETA = progress.Time
+			}
+
+			if !task.started.IsZero() {
+				task.state.Elapsed = time.Since(task.started)
+			}
+		}
+
+		// File events are printed after the file is transferred, because
+		// out-format contains %b, while plain file names are printed before
+		isStats := task.options.Stats && task.stats.parseLine(logStr)
+		if isEvent && isFileTransfer(event) {
+			task.setFile(event.Path)
+			task.completeFile()
+		} else if !isProgress && !isEvent && !isStats && isFileNameLine(logStr) {
+			task.setFile(logStr)
 		}
 
-		task.log.Stdout += logStr + "\n"
+		state, progressHandlers, fileHandlers := *task.state, task.onProgress, task.onFile
+		task.mu.Unlock()
+
+		io.WriteString(output, logStr+"\n")
+
+		if isProgress {
+			for _, handler := range progressHandlers {
+				handler(state)
+			}
+		}
+
+		if isEvent {
+			for _, handler := range fileHandlers {
+				handler(event)
+			}
+		}
 	}
+
+	return drain(scanner.Err(), stdout)
 }
 
-func processStderr(task *Task, stderr io.Reader) {
+// setFile starts tracking progress of the next file, must be called under lock
+func (t *Task) setFile(path string) {
+	t.state.File = path
+	t.state.FileBytes = 0
+	t.state.FileProgress = 0
+	t.fileCompleted = false
+}
+
+// completeFile adds current file to completed files, must be called under lock
+func (t *Task) completeFile() {
+	if t.state.File == "" || t.fileCompleted {
+		return
+	}
+	t.fileCompleted = true
+
+	if t.completedLimit == 0 {
+		return
+	}
+	// Old files are dropped in batches, so appending stays cheap
+	if len(t.completed) >= 2*t.completedLimit {
+		t.completed = append(t.completed[:0], t.completed[len(t.completed)-t.completedLimit+1:]...)
+	}
+	t.completed = append(t.completed, t.state.File)
+}
+
+func processStderr(task *Task, stderr io.Reader) error {
+	task.mu.RLock()
+	output := io.MultiWriter(task.stderr, task.stderrTail)
+	task.mu.RUnlock()
+
 	scanner := bufio.NewScanner(stderr)
 	for scanner.Scan() {
-		task.log.Stderr += scanner.Text() + "\n"
+		io.WriteString(output, scanner.Text()+"\n")
+	}
+
+	return drain(scanner.Err(), stderr)
+}
+
+// drain reads the rest of reader after scanner error
+func drain(err error, reader io.Reader) error {
+	if err != nil {
+		io.Copy(ioutil.Discard, reader)
 	}
+	return err
 }
 
 func getTaskProgress(remTotalString string) (int, int) {
@@ -135,11 +611,3 @@ func getTaskProgress(remTotalString string) (int, int) {
 
 	return remain, total
 }
-
-func getTaskSpeed(data [][]string) string {
-	if len(data) < 2 || len(data[1]) < 2 {
-		return ""
-	}
-
-	return data[1][1]
-}
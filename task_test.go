@@ -1,17 +1,38 @@
 package grsync
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestTask(t *testing.T) {
+	t.Run("exact sizes", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+		assert.NotContains(t, task.GetArguments(), "--human-readable")
+
+		task = NewTask("a", "b", RsyncOptions{HumanReadable: true})
+		assert.Contains(t, task.GetArguments(), "--human-readable")
+	})
+
 	t.Run("create new empty Task", func(t *testing.T) {
 		createdTask := NewTask("a", "b", RsyncOptions{})
 
 		assert.Empty(t, createdTask.Log(), "Task log should return empty string")
-		assert.Empty(t, createdTask.State(), "Task should inited with empty state")
+		assert.Equal(t, State{Status: StatusPending}, createdTask.State(), "Task should inited with empty pending state")
 	})
 }
 
@@ -34,8 +55,511 @@ func TestTaskProgressWithDifferentChkID(t *testing.T) {
 }
 
 func TestTaskSpeedParse(t *testing.T) {
-	speedMatcher := newMatcher(`(\d+\.\d+.{2}\/s)`)
 	const taskInfoString = `0.00kB/s \n 999,999 99%  999.99kB/s    0:00:59 (xfr#9, ir-chk=999/9999)`
-	speed := getTaskSpeed(speedMatcher.ExtractAllStringSubmatch(taskInfoString, 2))
-	assert.Equal(t, "999.99kB/s", speed)
+	progress, ok := parseProgressLine(taskInfoString)
+	assert.True(t, ok)
+	assert.Equal(t, "999.99kB/s", progress.Speed)
+	assert.InDelta(t, 999.99*1024, progress.Rate, 0.01)
+
+	for speed, rate := range map[string]float64{
+		"512B/s":       512,
+		"2.81MB/s":     2.81 * 1024 * 1024,
+		"1.25GB/s":     1.25 * 1024 * 1024 * 1024,
+		"2,345.67kB/s": 2345.67 * 1024,
+	} {
+		task := NewTask("a", "b", RsyncOptions{})
+		processStdout(task, strings.NewReader("  1,048,576 100%  "+speed+"    0:00:01 (xfr#1, to-chk=0/1)\n"))
+		assert.Equal(t, speed, task.State().Speed)
+		assert.InDelta(t, rate, task.State().Rate, 0.01, speed)
+	}
+}
+
+func TestTaskConcurrentState(t *testing.T) {
+	task := NewTask("a", "b", RsyncOptions{})
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		processStdout(task, stdoutReader)
+	}()
+	go func() {
+		defer wg.Done()
+		processStderr(task, stderrReader)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			fmt.Fprintf(stdoutWriter, "file%d\n", i)
+			fmt.Fprintf(stdoutWriter, "999,999 99%%  999.99kB/s    0:00:59 (xfr#%d, to-chk=%d/100)\n", i, 100-i)
+			fmt.Fprintf(stderrWriter, "warning %d\n", i)
+		}
+		stdoutWriter.Close()
+		stderrWriter.Close()
+	}()
+
+	for polling := true; polling; {
+		select {
+		case <-done:
+			polling = false
+		default:
+		}
+		state := task.State()
+		assert.True(t, state.Progress >= 0 && state.Progress <= 100)
+		task.Log()
+	}
+	wg.Wait()
+
+	state := task.State()
+	assert.Equal(t, 0, state.Remain)
+	assert.Equal(t, 100, state.Total)
+	assert.Equal(t, float64(100), state.Progress)
+	assert.Contains(t, task.Log().Stderr, "warning 100\n")
+}
+
+func TestTaskOnProgress(t *testing.T) {
+	task := NewTask("a", "b", RsyncOptions{})
+
+	var states []State
+	task.OnProgress(func(state State) {
+		states = append(states, state)
+	})
+
+	processStdout(task, strings.NewReader(
+		"file1\n"+
+			"      1,048,576 100%  659.30kB/s    0:00:01 (xfr#1, to-chk=1/2)\n"+
+			"file2\n"+
+			"      2,097,153 100%    2.81MB/s    0:00:00 (xfr#2, to-chk=0/2)\n",
+	))
+
+	assert.Len(t, states, 2)
+	assert.Equal(t, int64(1048576), states[0].FileBytes)
+	assert.Equal(t, int64(2097153), states[1].FileBytes)
+	assert.Equal(t, 1, states[0].Remain)
+	assert.Equal(t, float64(50), states[0].Progress)
+	assert.Equal(t, 0, states[1].Remain)
+	assert.Equal(t, float64(100), states[1].Progress)
+}
+
+func TestTaskProgress2(t *testing.T) {
+	task := NewTask("a", "b", RsyncOptions{Info: "progress2"})
+	assert.Contains(t, task.GetArguments(), "--no-inc-recursive")
+	assert.NotContains(t, task.GetArguments(), "--progress")
+
+	var states []State
+	task.OnProgress(func(state State) {
+		states = append(states, state)
+	})
+
+	processStdout(task, strings.NewReader(
+		"  1,048,576,123  10%  100.00MB/s    0:01:30\n"+
+			"  5,242,880,617  50%  100.00MB/s    0:00:50 (xfr#1, to-chk=0/1)\n",
+	))
+
+	assert.Len(t, states, 2)
+	assert.Equal(t, int64(1048576123), states[0].Transferred)
+	assert.Equal(t, float64(10), states[0].Progress)
+	assert.Equal(t, float64(100*1024*1024), states[0].Rate)
+	assert.Equal(t, 90*time.Second, states[0].ETA)
+	assert.Equal(t, int64(5242880617), states[1].Transferred)
+	assert.Equal(t, float64(50), states[1].Progress)
+	assert.Equal(t, 90*time.Second, states[1].ETA)
+}
+
+func TestTaskProgressCounters(t *testing.T) {
+	task := NewTask("a", "b", RsyncOptions{})
+	task.started = time.Now().Add(-time.Minute)
+
+	var states []State
+	task.OnProgress(func(state State) {
+		states = append(states, state)
+	})
+
+	processStdout(task, strings.NewReader(
+		"big.iso\n"+
+			"    524,288,000  50%  100.00MB/s    0:00:05\n"+
+			"  1,048,576,000 100%  100.00MB/s    0:00:10 (xfr#3, to-chk=7/10)\n",
+	))
+
+	assert.Len(t, states, 2)
+	assert.Equal(t, int64(524288000), states[0].FileBytes)
+	assert.Equal(t, float64(50), states[0].FileProgress)
+	assert.Equal(t, float64(100*1024*1024), states[0].Rate)
+	assert.Equal(t, 5*time.Second, states[0].ETA)
+	assert.True(t, states[0].Elapsed >= time.Minute)
+	assert.Equal(t, int64(1048576000), states[1].FileBytes)
+	assert.Equal(t, time.Duration(0), states[1].ETA)
+	assert.Equal(t, 3, states[1].Files)
+	assert.Equal(t, float64(30), states[1].Progress)
+}
+
+func TestTaskCurrentFile(t *testing.T) {
+	t.Run("file names before progress", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+
+		var files []string
+		task.OnProgress(func(state State) {
+			files = append(files, state.File)
+		})
+
+		processStdout(task, strings.NewReader(
+			"sending incremental file list\n"+
+				"created directory b\n"+
+				"dir/\n"+
+				"dir/first.bin\n"+
+				"    524,288,000  50%  100.00MB/s    0:00:05\n"+
+				"  1,048,576,000 100%  100.00MB/s    0:00:10 (xfr#1, to-chk=1/3)\n"+
+				"dir/second.bin\n"+
+				"         32,768   0%    0.00kB/s    0:00:00\n",
+		))
+
+		assert.Equal(t, []string{"dir/first.bin", "dir/first.bin", "dir/second.bin"}, files)
+		assert.Equal(t, []string{"dir/first.bin"}, task.CompletedFiles())
+
+		state := task.State()
+		assert.Equal(t, "dir/second.bin", state.File)
+		assert.Equal(t, int64(32768), state.FileBytes)
+		assert.Equal(t, float64(0), state.FileProgress)
+	})
+
+	t.Run("file events after progress", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{OutFormat: true})
+
+		processStdout(task, strings.NewReader(
+			"          1,024 100%    0.00kB/s    0:00:00 (xfr#1, to-chk=2/3)\n"+
+				">f+++++++++ 1024 1024 first.txt\n"+
+				".f...p..... 1024 0 same.txt\n"+
+				"          2,048 100%    0.00kB/s    0:00:00 (xfr#2, to-chk=0/3)\n"+
+				">f.st...... 2048 2048 second.txt\n",
+		))
+
+		assert.Equal(t, []string{"first.txt", "second.txt"}, task.CompletedFiles())
+		assert.Equal(t, "second.txt", task.State().File)
+	})
+}
+
+func TestTaskLogOutput(t *testing.T) {
+	t.Run("line buffer", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+		stdout, stderr := NewLineBuffer(2), NewLineBuffer(2)
+		task.SetLogOutput(stdout, stderr)
+
+		processStdout(task, strings.NewReader("file1\nfile2\nfile3\n"))
+		processStderr(task, strings.NewReader("error1\n"))
+
+		assert.Equal(t, Log{Stdout: "file2\nfile3\n", Stderr: "error1\n"}, task.Log())
+	})
+
+	t.Run("writers without log", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+		task.SetLogOutput(ioutil.Discard, nil)
+
+		processStdout(task, strings.NewReader("file1\n"))
+		processStderr(task, strings.NewReader("error1\n"))
+
+		assert.Empty(t, task.Log())
+		assert.Equal(t, "error1\n", task.stderrTail.String())
+	})
+
+	t.Run("tee", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+		stdout := &bytes.Buffer{}
+		task.SetLogOutput(io.MultiWriter(stdout, NewLineBuffer(10)), nil)
+
+		processStdout(task, strings.NewReader("file1\n"))
+		assert.Equal(t, "file1\n", stdout.String())
+	})
+}
+
+func TestTaskRunWaitsForOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("output is complete after Run", func(t *testing.T) {
+		task := NewTask("a", dir, RsyncOptions{})
+		useCommand(task.rsync, "sh", "-c", `
+			echo file.txt
+			printf '      1,024 100%%    0.00kB/s    0:00:00 (xfr#1, to-chk=0/1)\n'
+			echo 'last warning' >&2
+		`)
+
+		assert.NoError(t, task.Run())
+		assert.Equal(t, float64(100), task.State().Progress)
+		assert.Equal(t, []string{"file.txt"}, task.CompletedFiles())
+		assert.Equal(t, "last warning\n", task.Log().Stderr)
+	})
+
+	t.Run("scanner errors are returned", func(t *testing.T) {
+		task := NewTask("a", dir, RsyncOptions{})
+		useCommand(task.rsync, "sh", "-c", `
+			head -c 100000 /dev/zero | tr '\0' x
+			head -c 100000 /dev/zero | tr '\0' y
+			echo done
+		`)
+
+		err := task.Run()
+		assert.True(t, errors.Is(err, bufio.ErrTooLong))
+	})
+
+	t.Run("rsync error has priority", func(t *testing.T) {
+		task := NewTask("a", dir, RsyncOptions{})
+		useCommand(task.rsync, "sh", "-c", `
+			head -c 100000 /dev/zero | tr '\0' x
+			echo 'protocol mismatch' >&2
+			exit 2
+		`)
+
+		err := task.Run()
+		assert.Equal(t, &RsyncError{Code: ExitProtocol, Stderr: "protocol mismatch\n"}, withoutCause(err))
+	})
+}
+
+// withoutCause returns a copy of *RsyncError without underlying error
+func withoutCause(err error) error {
+	if rsyncErr, ok := err.(*RsyncError); ok {
+		return &RsyncError{Code: rsyncErr.Code, Stderr: rsyncErr.Stderr}
+	}
+	return err
+}
+
+func TestTaskPause(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires SIGSTOP")
+	}
+
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("not running", func(t *testing.T) {
+		task := NewTask("a", dir, RsyncOptions{})
+		assert.Equal(t, ErrNotRunning, task.Pause())
+		assert.Equal(t, ErrNotRunning, task.Resume())
+	})
+
+	t.Run("paused rsync doesn't exit until resumed", func(t *testing.T) {
+		task := NewTask("a", dir, RsyncOptions{})
+		useCommand(task.rsync, "sh", "-c", "echo started; sleep 0.2")
+
+		started := make(chan struct{})
+		var once sync.Once
+		task.SetLogOutput(writerFunc(func(p []byte) (int, error) {
+			once.Do(func() { close(started) })
+			return len(p), nil
+		}), nil)
+
+		done := make(chan error, 1)
+		go func() { done <- task.Run() }()
+
+		<-started
+		assert.NoError(t, task.Pause())
+		assert.True(t, task.State().Paused)
+
+		select {
+		case err := <-done:
+			t.Fatalf("paused task has finished: %v", err)
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		assert.NoError(t, task.Resume())
+		assert.False(t, task.State().Paused)
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("cancelled while paused", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{Stdout: "started\n", Duration: time.Hour})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- task.RunContext(ctx) }()
+
+		for len(executor.Commands()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		assert.NoError(t, task.Pause())
+
+		cancel()
+		assert.Equal(t, context.Canceled, <-done)
+		assert.Equal(t, []os.Signal{pauseSignal, terminateSignal, resumeSignal}, executor.Signals())
+		assert.False(t, task.State().Paused)
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestTaskStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("succeeded", func(t *testing.T) {
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: NewFakeExecutor(FakeRun{})})
+
+		before := time.Now()
+		assert.NoError(t, task.Run())
+
+		state := task.State()
+		assert.Equal(t, StatusSucceeded, state.Status)
+		assert.False(t, state.StartedAt.Before(before))
+		assert.False(t, state.FinishedAt.Before(state.StartedAt))
+		assert.Equal(t, state.FinishedAt.Sub(state.StartedAt), state.Elapsed)
+		assert.Equal(t, ExitSuccess, state.ExitCode)
+		assert.Empty(t, state.Error)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{ExitCode: int(ExitPartial)})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		err := task.Run()
+		assert.Error(t, err)
+
+		state := task.State()
+		assert.Equal(t, StatusFailed, state.Status)
+		assert.Equal(t, ExitPartial, state.ExitCode)
+		assert.Equal(t, err.Error(), state.Error)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{Duration: time.Hour})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, task.RunContext(ctx))
+
+		state := task.State()
+		assert.Equal(t, StatusCancelled, state.Status)
+		assert.Equal(t, exitCodeKilledBySignal, state.ExitCode)
+		assert.Equal(t, context.DeadlineExceeded.Error(), state.Error)
+	})
+
+	t.Run("not started", func(t *testing.T) {
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: NewFakeExecutor()})
+
+		err := task.Run()
+		assert.Error(t, err)
+
+		state := task.State()
+		assert.Equal(t, StatusFailed, state.Status)
+		assert.Equal(t, exitCodeNotExited, state.ExitCode)
+		assert.Equal(t, err.Error(), state.Error)
+	})
+
+	t.Run("cancelled before start", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, context.Canceled, task.RunContext(ctx))
+
+		state := task.State()
+		assert.Equal(t, StatusCancelled, state.Status)
+		assert.Equal(t, exitCodeNotExited, state.ExitCode)
+	})
+
+	t.Run("running", func(t *testing.T) {
+		executor := NewFakeExecutor(FakeRun{Duration: time.Hour})
+		task := NewTaskCommand("a", dir, RsyncOptions{}, CommandOptions{Executor: executor})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- task.RunContext(ctx) }()
+
+		for len(executor.Commands()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		state := task.State()
+		assert.Equal(t, StatusRunning, state.Status)
+		assert.False(t, state.StartedAt.IsZero())
+		assert.True(t, state.FinishedAt.IsZero())
+
+		cancel()
+		<-done
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(State{Status: StatusFailed, ExitCode: ExitTimeout, Error: "timeout"})
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"status":"failed"`)
+		assert.Contains(t, string(data), `"exitCode":30`)
+		assert.Contains(t, string(data), `"error":"timeout"`)
+		assert.Contains(t, string(data), `"startedAt":"0001-01-01T00:00:00Z"`)
+	})
+}
+
+func TestTaskRunResetsState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	executor := NewFakeExecutor(
+		FakeRun{Stdout: "file1\n" +
+			"          1,024 100%    0.00kB/s    0:00:00 (xfr#1, to-chk=0/1)\n" +
+			"Number of files: 1 (reg: 1)\n"},
+		FakeRun{},
+	)
+	task := NewTaskCommand("a", dir, RsyncOptions{Stats: true}, CommandOptions{Executor: executor})
+
+	assert.NoError(t, task.Run())
+	assert.Equal(t, float64(100), task.State().Progress)
+	assert.Equal(t, []string{"file1"}, task.CompletedFiles())
+	assert.Equal(t, 1, task.Stats().Files)
+
+	assert.NoError(t, task.Run())
+	state := task.State()
+	assert.Equal(t, StatusSucceeded, state.Status)
+	assert.Equal(t, float64(0), state.Progress)
+	assert.Empty(t, state.File)
+	assert.Equal(t, 0, state.Files)
+	assert.Empty(t, task.CompletedFiles())
+	assert.Empty(t, task.Stats())
+}
+
+func TestTaskCompletedFilesLimit(t *testing.T) {
+	output := strings.Builder{}
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&output, "file%d\n          1,024 100%%    0.00kB/s    0:00:00 (xfr#%d, to-chk=%d/10)\n", i, i, 10-i)
+	}
+
+	t.Run("last files are kept", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+		task.SetCompletedFilesLimit(3)
+		processStdout(task, strings.NewReader(output.String()))
+
+		assert.Equal(t, []string{"file8", "file9", "file10"}, task.CompletedFiles())
+		assert.Equal(t, 10, task.State().Files)
+		assert.True(t, len(task.completed) <= 6)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+		task.SetCompletedFilesLimit(0)
+		processStdout(task, strings.NewReader(output.String()))
+
+		assert.Empty(t, task.CompletedFiles())
+		assert.Equal(t, "file10", task.State().File)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		task := NewTask("a", "b", RsyncOptions{})
+		processStdout(task, strings.NewReader(output.String()))
+		assert.Len(t, task.CompletedFiles(), 10)
+	})
 }
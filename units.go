@@ -0,0 +1,130 @@
+package grsync
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	decimalUnitBase = 1000
+	binaryUnitBase  = 1024
+)
+
+// separatorReplacer removes thousands separators which can't be decimal points
+var separatorReplacer = strings.NewReplacer("'", "", " ", "", "\u00a0", "", "\u202f", "")
+
+// ParseSize converts size printed by rsync to bytes. It accepts plain numbers,
+// numbers with thousands separators of any locale (1,234,567 or 1.234.567)
+// and human-readable numbers with K, M, G, T, P suffixes, which are powers of
+// 1000 as rsync prints them with a single --human-readable. Suffixes like KiB
+// are powers of 1024.
+func ParseSize(value string) (int64, error) {
+	return parseSizeBase(value, decimalUnitBase)
+}
+
+// ParseBinarySize is like ParseSize, but K, M, G, T, P suffixes are powers of
+// 1024 as rsync prints them with --human-readable twice (-hh)
+func ParseBinarySize(value string) (int64, error) {
+	return parseSizeBase(value, binaryUnitBase)
+}
+
+// ParseRate converts transfer speed printed by rsync to bytes per second.
+// Progress speed like 999.99kB/s, 2.81MB/s or 512B/s uses powers of 1024,
+// summary speed like 3.06K bytes/sec uses powers of 1000.
+func ParseRate(value string) (float64, error) {
+	number := strings.TrimSpace(value)
+	switch {
+	case strings.HasSuffix(number, "bytes/sec"):
+		rate, err := parseNumber(strings.TrimSuffix(number, "bytes/sec"), decimalUnitBase)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate %q", value)
+		}
+		return rate, nil
+	case strings.HasSuffix(number, "B/s"):
+		rate, err := parseNumber(strings.TrimSuffix(number, "B/s"), binaryUnitBase)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate %q", value)
+		}
+		return rate, nil
+	}
+
+	return 0, fmt.Errorf("invalid rate %q", value)
+}
+
+func parseSizeBase(value string, base float64) (int64, error) {
+	number := strings.TrimSpace(value)
+	number = strings.TrimSuffix(number, "bytes")
+	number = strings.TrimSuffix(number, "B")
+
+	size, err := parseNumber(number, base)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(math.Round(size)), nil
+}
+
+// parseNumber parses number with optional unit suffix, which is a power of
+// base, or a power of 1024 if suffix ends with i
+func parseNumber(value string, base float64) (float64, error) {
+	const units = "KMGTP"
+
+	number := strings.TrimSpace(value)
+	if strings.HasSuffix(number, "i") {
+		base = binaryUnitBase
+		number = strings.TrimSuffix(number, "i")
+	}
+
+	multiplier := float64(1)
+	if number != "" {
+		if index := strings.IndexRune(units, unicode.ToUpper(rune(number[len(number)-1]))); index >= 0 {
+			multiplier = math.Pow(base, float64(index+1))
+			number = number[:len(number)-1]
+		}
+	}
+
+	result, err := strconv.ParseFloat(normalizeNumber(number), 64)
+	if err != nil {
+		return 0, err
+	}
+	return result * multiplier, nil
+}
+
+// normalizeNumber removes thousands separators and replaces decimal separator
+// with a dot. Separator is decimal if it's the last one of different kinds or
+// it isn't followed by three digits, rsync prints decimals with two digits.
+func normalizeNumber(number string) string {
+	const thousandsGroup = 3
+
+	number = separatorReplacer.Replace(number)
+
+	decimal := strings.LastIndexAny(number, ".,")
+	if decimal >= 0 {
+		separator := number[decimal : decimal+1]
+		other := ","
+		if separator == "," {
+			other = "."
+		}
+
+		isOnly := strings.Count(number, separator) == 1 && !strings.Contains(number, other)
+		if isOnly && len(number)-decimal-1 == thousandsGroup || !isOnly && !strings.Contains(number, other) {
+			decimal = -1
+		}
+	}
+
+	normalized := strings.Builder{}
+	for i, char := range number {
+		switch {
+		case char >= '0' && char <= '9', char == '-':
+			normalized.WriteRune(char)
+		case i == decimal:
+			normalized.WriteByte('.')
+		case char != '.' && char != ',':
+			// Keep unknown characters so the number fails to parse
+			normalized.WriteRune(char)
+		}
+	}
+	return normalized.String()
+}
@@ -0,0 +1,76 @@
+package grsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"0":           0,
+		"999":         999,
+		"1234567":     1234567,
+		"1,234,567":   1234567,
+		"1.234.567":   1234567,
+		"1'234'567":   1234567,
+		"1 234 567":   1234567,
+		"1,234":       1234,
+		"1.23K":       1230,
+		"1,23K":       1230,
+		"659.30M":     659300000,
+		"2G":          2000000000,
+		" 1.05T ":     1050000000000,
+		"1.00KiB":     1024,
+		"1.50Mi":      1572864,
+		"1,234 bytes": 1234,
+		"1.23K bytes": 1230,
+	}
+
+	for value, expected := range cases {
+		size, err := ParseSize(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, size, value)
+	}
+
+	for _, value := range []string{"", "kB/s", "1.2.3,4x"} {
+		_, err := ParseSize(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseBinarySize(t *testing.T) {
+	size, err := ParseBinarySize("1.50M")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1572864), size)
+
+	size, err = ParseBinarySize("1,234,567")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1234567), size)
+}
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]float64{
+		"0.00kB/s":           0,
+		"999.99kB/s":         999.99 * 1024,
+		"999,99kB/s":         999.99 * 1024,
+		"2,345.67kB/s":       2345.67 * 1024,
+		"2.81MB/s":           2.81 * 1024 * 1024,
+		"1.50GB/s":           1.5 * 1024 * 1024 * 1024,
+		"512B/s":             512,
+		"1.00MiB/s":          1024 * 1024,
+		"3.06K bytes/sec":    3060,
+		"3,058.00 bytes/sec": 3058,
+	}
+
+	for value, expected := range cases {
+		rate, err := ParseRate(value)
+		assert.NoError(t, err, value)
+		assert.InDelta(t, expected, rate, 0.01, value)
+	}
+
+	for _, value := range []string{"fast", "100", "kB/s"} {
+		_, err := ParseRate(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,277 @@
+package grsync
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version contains information printed by rsync --version
+type Version struct {
+	// Release is rsync version, e.g. 3.2.7
+	Release  string `json:"release"`
+	Major    int    `json:"major"`
+	Minor    int    `json:"minor"`
+	Patch    int    `json:"patch"`
+	Protocol int    `json:"protocol"`
+	// Capabilities, e.g. ACLs, xattrs, iconv
+	Capabilities []string `json:"capabilities"`
+	// Checksums supported by rsync 3.2+
+	Checksums []string `json:"checksums"`
+	// Compressions supported by rsync 3.2+, e.g. zstd, lz4
+	Compressions []string `json:"compressions"`
+}
+
+var versionMatcher = newMatcher(`version v?(\d+)\.(\d+)(?:\.(\d+))?\S*\s+protocol version (\d+)`)
+
+// DetectVersion runs rsync --version and parses its output
+func DetectVersion(command CommandOptions) (Version, error) {
+	command.Nice, command.IONiceClass = 0, 0
+
+	output := bytes.Buffer{}
+	versionCommand := command.command([]string{"--version"})
+	versionCommand.Stdout = &output
+
+	process, err := command.executor().Start(versionCommand)
+	if err != nil {
+		return Version{}, err
+	}
+	if err := process.Wait(); err != nil {
+		return Version{}, err
+	}
+	return ParseVersion(output.String())
+}
+
+// ParseVersion parses rsync --version output
+func ParseVersion(output string) (Version, error) {
+	const (
+		indexMajor = iota + 1
+		indexMinor
+		indexPatch
+		indexProtocol
+	)
+
+	matches := versionMatcher.ExtractAllStringSubmatch(output, 1)
+	if len(matches) == 0 {
+		return Version{}, fmt.Errorf("unknown rsync version: %q", firstLine(output))
+	}
+
+	version := Version{}
+	version.Major, _ = strconv.Atoi(matches[0][indexMajor])
+	version.Minor, _ = strconv.Atoi(matches[0][indexMinor])
+	version.Patch, _ = strconv.Atoi(matches[0][indexPatch])
+	version.Protocol, _ = strconv.Atoi(matches[0][indexProtocol])
+	version.Release = fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Patch)
+
+	// Sections look like "Capabilities:" followed by indented lines
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			section = ""
+			continue
+		}
+
+		// Older rsync prints items on the section header line as well
+		if line[0] != ' ' && line[0] != '\t' {
+			separator := strings.IndexByte(line, ':')
+			if separator < 0 {
+				section = ""
+				continue
+			}
+			section, line = line[:separator], line[separator+1:]
+		}
+
+		switch section {
+		case "Capabilities":
+			for _, capability := range strings.Split(line, ",") {
+				if capability = strings.TrimSpace(capability); capability != "" {
+					version.Capabilities = append(version.Capabilities, capability)
+				}
+			}
+		case "Checksum list":
+			version.Checksums = append(version.Checksums, listItems(line)...)
+		case "Compress list":
+			version.Compressions = append(version.Compressions, listItems(line)...)
+		}
+	}
+
+	return version, nil
+}
+
+// AtLeast reports whether version is the same or newer than major.minor.patch
+func (v Version) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// HasCapability reports whether rsync is built with the capability, the
+// name is case insensitive
+func (v Version) HasCapability(name string) bool {
+	for _, capability := range v.Capabilities {
+		if strings.EqualFold(capability, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsCompression reports whether rsync supports compression algorithm
+func (v Version) SupportsCompression(name string) bool {
+	// Compress list is printed since 3.2.0, older versions know only zlib
+	compressions := v.Compressions
+	if len(compressions) == 0 {
+		compressions = []string{"zlib"}
+	}
+
+	for _, compression := range compressions {
+		if strings.EqualFold(compression, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsProgress2 reports whether rsync supports --info=progress2
+func (v Version) SupportsProgress2() bool {
+	return v.AtLeast(3, 1, 0)
+}
+
+// optionRequirement describes an option unsupported by some rsync builds
+type optionRequirement struct {
+	name      string
+	used      func(RsyncOptions) bool
+	supported func(Version) bool
+	disable   func(*RsyncOptions)
+}
+
+var optionRequirements = []optionRequirement{
+	{
+		name:      "acls",
+		used:      func(o RsyncOptions) bool { return o.ACLs },
+		supported: func(v Version) bool { return v.HasCapability("ACLs") },
+		disable:   func(o *RsyncOptions) { o.ACLs = false },
+	},
+	{
+		name:      "xattrs",
+		used:      func(o RsyncOptions) bool { return o.XAttrs },
+		supported: func(v Version) bool { return v.HasCapability("xattrs") },
+		disable:   func(o *RsyncOptions) { o.XAttrs = false },
+	},
+	{
+		name:      "ipv6",
+		used:      func(o RsyncOptions) bool { return o.IPv6 },
+		supported: func(v Version) bool { return v.HasCapability("IPv6") },
+		disable:   func(o *RsyncOptions) { o.IPv6 = false },
+	},
+	{
+		name:      "inplace",
+		used:      func(o RsyncOptions) bool { return o.Inplace },
+		supported: func(v Version) bool { return v.HasCapability("inplace") },
+		disable:   func(o *RsyncOptions) { o.Inplace = false },
+	},
+	{
+		name:      "protect-args",
+		used:      func(o RsyncOptions) bool { return o.ProtectArgs },
+		supported: func(v Version) bool { return v.AtLeast(3, 0, 0) },
+		disable:   func(o *RsyncOptions) { o.ProtectArgs = false },
+	},
+	{
+		name:      "mkpath",
+		used:      func(o RsyncOptions) bool { return o.Mkpath },
+		supported: func(v Version) bool { return v.AtLeast(3, 2, 3) },
+		disable:   func(o *RsyncOptions) { o.Mkpath = false },
+	},
+	{
+		name:      "info",
+		used:      func(o RsyncOptions) bool { return o.Info != "" },
+		supported: func(v Version) bool { return v.AtLeast(3, 1, 0) },
+		disable:   func(o *RsyncOptions) { o.Info = "" },
+	},
+	{
+		name:      "chown",
+		used:      func(o RsyncOptions) bool { return o.Chown != "" },
+		supported: func(v Version) bool { return v.AtLeast(3, 1, 0) },
+		disable:   func(o *RsyncOptions) { o.Chown = "" },
+	},
+	{
+		name:      "append-verify",
+		used:      func(o RsyncOptions) bool { return o.AppendVerify },
+		supported: func(v Version) bool { return v.AtLeast(3, 0, 7) },
+		disable:   func(o *RsyncOptions) { o.AppendVerify = false },
+	},
+	{
+		name:      "fake-super",
+		used:      func(o RsyncOptions) bool { return o.FakeSuper },
+		supported: func(v Version) bool { return v.AtLeast(3, 0, 0) },
+		disable:   func(o *RsyncOptions) { o.FakeSuper = false },
+	},
+	{
+		name:      "delete-delay",
+		used:      func(o RsyncOptions) bool { return o.DeleteDelay },
+		supported: func(v Version) bool { return v.AtLeast(3, 0, 0) },
+		disable:   func(o *RsyncOptions) { o.DeleteDelay = false },
+	},
+	{
+		name:      "contimeout",
+		used:      func(o RsyncOptions) bool { return o.Contimeout > 0 },
+		supported: func(v Version) bool { return v.AtLeast(3, 0, 0) },
+		disable:   func(o *RsyncOptions) { o.Contimeout = 0 },
+	},
+}
+
+// UnsupportedOptions returns names of options which rsync version doesn't support
+func (v Version) UnsupportedOptions(options RsyncOptions) []string {
+	unsupported := []string{}
+	for _, requirement := range optionRequirements {
+		if requirement.used(options) && !requirement.supported(v) {
+			unsupported = append(unsupported, requirement.name)
+		}
+	}
+	return unsupported
+}
+
+// AdaptOptions returns options without those which rsync version doesn't support
+func (v Version) AdaptOptions(options RsyncOptions) RsyncOptions {
+	for _, requirement := range optionRequirements {
+		if requirement.used(options) && !requirement.supported(v) {
+			requirement.disable(&options)
+		}
+	}
+	return options
+}
+
+// GetVersionArguments returns arguments like GetArguments, but refuses
+// options which rsync version doesn't support
+func GetVersionArguments(options RsyncOptions, version Version) ([]string, error) {
+	if unsupported := version.UnsupportedOptions(options); len(unsupported) > 0 {
+		return nil, fmt.Errorf("rsync %s doesn't support options: --%s", version.Release, strings.Join(unsupported, ", --"))
+	}
+	return GetArguments(options), nil
+}
+
+// listItems splits line of rsync 3.2 list like "xxh128 xxh3 xxh64 (xxhash) md5"
+func listItems(line string) []string {
+	items := []string{}
+	for _, item := range strings.Fields(line) {
+		if !strings.HasPrefix(item, "(") {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func firstLine(s string) string {
+	if index := strings.IndexByte(s, '\n'); index >= 0 {
+		return s[:index]
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package grsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rsync327Version = `rsync  version 3.2.7  protocol version 31
+Copyright (C) 1996-2022 by Andrew Tridgell, Wayne Davison, and others.
+Web site: https://rsync.samba.org/
+Capabilities:
+    64-bit files, 64-bit inums, 64-bit timestamps, 64-bit long ints,
+    socketpairs, symlinks, symtimes, hardlinks, hardlink-specials,
+    hardlink-symlinks, IPv6, atimes, batchfiles, inplace, append, ACLs,
+    xattrs, optional secluded-args, iconv, prealloc, stop-at, no crtimes
+Optimizations:
+    SIMD-roll, no asm-MD5
+Checksum list:
+    xxh128 xxh3 xxh64 (xxhash) md5 md4 sha1 none
+Compress list:
+    zstd lz4 zlibx zlib none
+Daemon auth list:
+    sha512 sha256 sha1 md5 md4
+
+rsync comes with ABSOLUTELY NO WARRANTY.  This is free software, and you
+are welcome to redistribute it under certain conditions.  See the GNU
+General Public Licence for details.
+`
+
+const rsync269Version = `rsync  version 2.6.9  protocol version 29
+Copyright (C) 1996-2006 by Andrew Tridgell, Wayne Davison, and others.
+<http://rsync.samba.org/>
+Capabilities: 64-bit files, socketpairs, hard links, symlinks, batchfiles,
+              inplace, IPv6, 64-bit system inums, 64-bit internal inums
+
+rsync comes with ABSOLUTELY NO WARRANTY.  This is free software, and you
+are welcome to redistribute it under certain conditions.  See the GNU
+General Public Licence for details.
+`
+
+func TestParseVersion(t *testing.T) {
+	t.Run("rsync 3.2", func(t *testing.T) {
+		version, err := ParseVersion(rsync327Version)
+		assert.NoError(t, err)
+		assert.Equal(t, "3.2.7", version.Release)
+		assert.Equal(t, 31, version.Protocol)
+		assert.True(t, version.HasCapability("acls"))
+		assert.True(t, version.HasCapability("xattrs"))
+		assert.True(t, version.HasCapability("iconv"))
+		assert.False(t, version.HasCapability("crtimes"))
+		assert.Equal(t, []string{"xxh128", "xxh3", "xxh64", "md5", "md4", "sha1", "none"}, version.Checksums)
+		assert.True(t, version.SupportsCompression("zstd"))
+		assert.True(t, version.SupportsCompression("lz4"))
+		assert.True(t, version.SupportsProgress2())
+	})
+
+	t.Run("rsync 2.6", func(t *testing.T) {
+		version, err := ParseVersion(rsync269Version)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version.Major)
+		assert.Equal(t, 6, version.Minor)
+		assert.Equal(t, 9, version.Patch)
+		assert.Equal(t, 29, version.Protocol)
+		assert.True(t, version.HasCapability("IPv6"))
+		assert.False(t, version.HasCapability("ACLs"))
+		assert.True(t, version.SupportsCompression("zlib"))
+		assert.False(t, version.SupportsCompression("zstd"))
+		assert.False(t, version.SupportsProgress2())
+	})
+
+	t.Run("unknown output", func(t *testing.T) {
+		_, err := ParseVersion("openrsync: protocol version 29")
+		assert.Error(t, err)
+	})
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	version := Version{Major: 3, Minor: 1, Patch: 3}
+	assert.True(t, version.AtLeast(3, 1, 3))
+	assert.True(t, version.AtLeast(3, 0, 9))
+	assert.True(t, version.AtLeast(2, 9, 9))
+	assert.False(t, version.AtLeast(3, 1, 4))
+	assert.False(t, version.AtLeast(3, 2, 0))
+}
+
+func TestVersionOptions(t *testing.T) {
+	version, err := ParseVersion(rsync269Version)
+	assert.NoError(t, err)
+
+	options := RsyncOptions{Archive: true, ACLs: true, XAttrs: true, Info: "progress2", IPv6: true}
+	assert.Equal(t, []string{"acls", "xattrs", "info"}, version.UnsupportedOptions(options))
+	assert.Equal(t, RsyncOptions{Archive: true, IPv6: true}, version.AdaptOptions(options))
+
+	_, err = GetVersionArguments(options, version)
+	assert.EqualError(t, err, "rsync 2.6.9 doesn't support options: --acls, --xattrs, --info")
+
+	args, err := GetVersionArguments(RsyncOptions{Archive: true}, version)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--archive"}, args)
+}